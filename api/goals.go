@@ -0,0 +1,166 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martenwallewein/todo-service/pkg/markdown"
+	"github.com/martenwallewein/todo-service/pkg/todos"
+	"github.com/martenwallewein/todo-service/pkg/workspace"
+)
+
+// goalRequest is the body to add or edit a goal.
+type goalRequest struct {
+	markdown.TodoItem
+	// Yearly adds the goal to the goals of the year instead of the month
+	Yearly bool
+}
+
+// monthQuery reads the month given as YYYY-MM, the current month if
+// missing.
+func monthQuery(c *gin.Context) (time.Time, error) {
+	value := c.Query("month")
+	if value == "" {
+		return time.Now(), nil
+	}
+	month, err := time.ParseInLocation("2006-01", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid month %s, expected YYYY-MM", value)
+	}
+	return month, nil
+}
+
+// GetGoals returns the goals of a month given by month and of its year
+// with their progress.
+func (api *RESTApiV1) GetGoals(c *gin.Context) {
+	month, err := monthQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	progress, err := api.goalService.GetProgress(c.Request.Context(), month)
+	if err != nil {
+		respondError(c, err, "Failed to fetch goals")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": progress,
+	})
+}
+
+// GetGoalSummary returns which goals moved in a month given by month.
+func (api *RESTApiV1) GetGoalSummary(c *gin.Context) {
+	month, err := monthQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	summary, err := api.goalService.GetSummary(c.Request.Context(), month)
+	if err != nil {
+		respondError(c, err, "Failed to fetch goal summary")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": summary,
+	})
+}
+
+func (api *RESTApiV1) AddGoal(c *gin.Context) {
+	var goal goalRequest
+	if err := c.ShouldBindJSON(&goal); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if goal.Task == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing task"})
+		return
+	}
+	if err := validateTodo(goal.TodoItem); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var item *markdown.TodoItem
+	message := todos.Message(todos.ActionAdd, goal.Task, "Add goal %s", goal.Task)
+	err := api.ws.Mutate(c.Request.Context(), message, func(uow *workspace.UnitOfWork) error {
+		var err error
+		item, err = api.goalService.AddGoal(uow, goal.Task, goal.Yearly, goal.Metadata, goal.Notes)
+		if err != nil {
+			return err
+		}
+		todos.Resolve(message, "Add goal %s", item)
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "Failed to add goal")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"task": item.Task,
+		"id":   item.ID,
+		"goal": item,
+	})
+}
+
+// EditGoal replaces the text of the goal addressed by the path and updates
+// its metadata.
+func (api *RESTApiV1) EditGoal(c *gin.Context) {
+	var goal goalRequest
+	if err := c.ShouldBindJSON(&goal); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := goal.Metadata.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	api.mutateGoal(c, todos.ActionEdit, "Edit goal %s", "Failed to edit goal", func(uow *workspace.UnitOfWork, ref string) (*markdown.TodoItem, error) {
+		return api.goalService.EditGoal(uow, ref, goal.Task, goal.Metadata)
+	})
+}
+
+func (api *RESTApiV1) CompleteGoal(c *gin.Context) {
+	api.mutateGoal(c, todos.ActionComplete, "Complete goal %s", "Failed to complete goal", api.goalService.CompleteGoal)
+}
+
+// RemoveGoal removes the goal addressed by the path, tasks linking to it
+// are kept.
+func (api *RESTApiV1) RemoveGoal(c *gin.Context) {
+	api.mutateGoal(c, todos.ActionRemove, "Remove goal %s", "Failed to remove goal", api.goalService.RemoveGoal)
+}
+
+// mutateGoal applies fn to the goal addressed by the path in a single
+// commit. The path holds the ID of the goal, or its text for goals of the
+// current month and the year.
+func (api *RESTApiV1) mutateGoal(c *gin.Context, action, subject, failure string, fn func(*workspace.UnitOfWork, string) (*markdown.TodoItem, error)) {
+	ref := c.Param("id")
+	var item *markdown.TodoItem
+	message := todos.Message(action, ref, subject, ref)
+	err := api.ws.Mutate(c.Request.Context(), message, func(uow *workspace.UnitOfWork) error {
+		var err error
+		item, err = fn(uow, ref)
+		if err != nil {
+			return err
+		}
+		todos.Resolve(message, subject, item)
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, failure)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"task": item.Task,
+		"id":   item.ID,
+		"goal": item,
+	})
+}
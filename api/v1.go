@@ -1,13 +1,25 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/martenwallewein/todo-service/pkg/activity"
+	"github.com/martenwallewein/todo-service/pkg/auth"
+	"github.com/martenwallewein/todo-service/pkg/cmdexec"
+	"github.com/martenwallewein/todo-service/pkg/git"
+	"github.com/martenwallewein/todo-service/pkg/goals"
 	"github.com/martenwallewein/todo-service/pkg/markdown"
 	"github.com/martenwallewein/todo-service/pkg/timetracking"
 	"github.com/martenwallewein/todo-service/pkg/todos"
+	"github.com/martenwallewein/todo-service/pkg/workspace"
 	"github.com/sirupsen/logrus"
 )
 
@@ -17,28 +29,138 @@ func path(endpoint string) string {
 
 type RESTApiV1 struct {
 	router              *gin.Engine
+	ws                  *workspace.Workspace
 	todoService         *todos.TodoService
 	timeTrackingService *timetracking.TimeTrackingService
+	goalService         *goals.GoalService
 }
 
 func (api *RESTApiV1) Serve(addr string) error {
 	return api.router.Run(addr)
 }
 
-func NewRESTApiV1(repoPath string) *RESTApiV1 {
+// statusClientClosedRequest is used if the client closed the connection
+// before the request was handled
+const statusClientClosedRequest = 499
+
+// respondError logs err and responds with message and a status code
+// matching the kind of error.
+func respondError(c *gin.Context, err error, message string) {
+	// The client is gone, nobody reads the response. Commands killed for
+	// it fail with context.Canceled too.
+	if errors.Is(err, context.Canceled) {
+		logrus.Debugf("%s, client closed the request: %v", message, err)
+		c.AbortWithStatus(statusClientClosedRequest)
+		return
+	}
+
+	logrus.Error(err)
+
+	var mergeErr *markdown.MergeError
+	if errors.As(err, &mergeErr) {
+		c.JSON(http.StatusConflict, gin.H{"error": message, "conflicts": mergeErr.Conflicts})
+		return
+	}
+
+	// A hand edit broke a file of the repository
+	var parseErr *markdown.ParseErrors
+	if errors.As(err, &parseErr) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": message, "file": filepath.Base(parseErr.File), "problems": parseErr.Errors})
+		return
+	}
+
+	var foreignErr *todos.ForeignCommitError
+	if errors.As(err, &foreignErr) {
+		c.JSON(http.StatusConflict, gin.H{"error": message, "detail": foreignErr.Error(), "commit": foreignErr.Commit.Hash})
+		return
+	}
+
+	var transitionErr *markdown.InvalidTransitionError
+	if errors.As(err, &transitionErr) || errors.Is(err, timetracking.ErrTaskCancelled) {
+		c.JSON(http.StatusConflict, gin.H{"error": message, "detail": err.Error()})
+		return
+	}
+
+	// A task addressed by text matched several tasks
+	var ambiguousErr *markdown.AmbiguousTaskError
+	if errors.As(err, &ambiguousErr) {
+		c.JSON(http.StatusConflict, gin.H{"error": message, "detail": ambiguousErr.Error(), "matches": ambiguousErr.Matches})
+		return
+	}
+
+	if errors.Is(err, git.ErrNotFound) || errors.Is(err, markdown.ErrTaskNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": message, "detail": err.Error()})
+		return
+	}
+
+	if errors.Is(err, todos.ErrNothingToUndo) || errors.Is(err, todos.ErrNothingToRedo) || errors.Is(err, git.ErrConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": message, "detail": err.Error()})
+		return
+	}
+
+	var execErr *cmdexec.ExecError
+	if errors.As(err, &execErr) {
+		status := http.StatusBadGateway
+		if execErr.TimedOut {
+			status = http.StatusGatewayTimeout
+		}
+		c.JSON(status, gin.H{"error": message, "exitCode": execErr.ExitCode, "detail": strings.TrimSpace(execErr.Stderr)})
+		return
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
+
+func NewRESTApiV1(ws *workspace.Workspace, authConfig auth.Config, todoConfig todos.Config) *RESTApiV1 {
 	router := gin.Default()
-	todoService := todos.NewTodoService(repoPath)
-	timeTrackingService := timetracking.NewTimeTrackingService(repoPath)
+	router.Use(auth.Middleware(authConfig))
+	todoService := todos.NewTodoService(ws, todoConfig)
+	timeTrackingService := timetracking.NewTimeTrackingService(ws)
+	goalService := goals.NewGoalService(ws, todoService, timeTrackingService, todoConfig)
 	api := &RESTApiV1{
 		router,
+		ws,
 		todoService,
 		timeTrackingService,
+		goalService,
 	}
 
-	router.POST(path("todos"), api.CompleteTodayTodo)
-	router.POST(path("todos/start"), api.StartTodayTodo)
-	router.GET(path("todos"), api.GetTodaysTodos)
-	router.PUT(path("todos"), api.AddTodayTodo)
+	// Only requests reading or changing todos roll over, undo and redo
+	// must not revert a rollover made on their behalf
+	todoRoutes := router.Group("")
+	switch todoConfig.RolloverTrigger {
+	case todos.RolloverRequest:
+		todoRoutes.Use(api.rolloverMiddleware)
+	case todos.RolloverSchedule:
+		todoService.ScheduleRollover()
+	}
+
+	todoRoutes.POST(path("todos"), api.CompleteTodayTodo)
+	todoRoutes.POST(path("todos/start"), api.StartTodayTodo)
+	todoRoutes.POST(path("todos/status"), api.SetTodoStatus)
+	router.POST(path("todos/rollover"), api.RolloverTodos)
+	todoRoutes.GET(path("todos"), api.GetTodaysTodos)
+	todoRoutes.PUT(path("todos"), api.AddTodayTodo)
+	todoRoutes.PUT(path("todos/:id/subtasks"), api.AddSubtask)
+	todoRoutes.PUT(path("todos/:id/notes"), api.SetTodoNotes)
+	todoRoutes.POST(path("todos/:id/subtasks"), api.CompleteSubtask)
+
+	router.GET(path("goals"), api.GetGoals)
+	router.GET(path("goals/summary"), api.GetGoalSummary)
+	router.PUT(path("goals"), api.AddGoal)
+	router.POST(path("goals/:id"), api.EditGoal)
+	router.POST(path("goals/:id/complete"), api.CompleteGoal)
+	router.DELETE(path("goals/:id"), api.RemoveGoal)
+
+	router.GET(path("timetracking"), api.GetTodaysTimeTrackings)
+
+	router.GET(path("sync"), api.GetSyncState)
+	router.POST(path("sync/flush"), api.Flush)
+
+	router.GET(path("activity"), api.GetActivity)
+
+	router.POST(path("undo"), api.Undo)
+	router.POST(path("redo"), api.Redo)
 
 	/*router.POST(path("projects/:id"), api.EditProject)
 	router.DELETE(path("projects/:id"), api.DeleteProject)
@@ -65,72 +187,394 @@ func (api *RESTApiV1) AddTodayTodo(c *gin.Context) {
 		return
 	}
 
-	if err := api.todoService.AddTodayTodo(todo.Task); err != nil {
-		logrus.Error(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add project"})
+	if err := validateTodo(todo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	item, err := api.todoService.AddTodayTodo(c.Request.Context(), todo.Task, todo.Metadata, todo.Notes)
+	if err != nil {
+		respondError(c, err, "Failed to add todo")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"task": todo.Task,
+		"id":   item.ID,
+		"todo": item,
 	})
 
 }
 
-func (api *RESTApiV1) CompleteTodayTodo(c *gin.Context) {
+// rolloverMiddleware rolls over the unfinished tasks on the first todo
+// request of a day. A failed rollover does not fail the request, it is tried again
+// on the next one.
+func (api *RESTApiV1) rolloverMiddleware(c *gin.Context) {
+	if err := api.todoService.RolloverOnce(c.Request.Context()); err != nil {
+		logrus.Errorf("Rollover failed: %v", err)
+	}
+	c.Next()
+}
+
+// RolloverTodos moves the unfinished tasks of past days to today.
+func (api *RESTApiV1) RolloverTodos(c *gin.Context) {
+	items, err := api.todoService.RolloverTodos(c.Request.Context())
+	if err != nil {
+		respondError(c, err, "Failed to roll over todos")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": items,
+	})
+}
+
+// validateTodo checks the metadata and notes of a todo to add.
+func validateTodo(todo markdown.TodoItem) error {
+	if err := todo.Metadata.Validate(); err != nil {
+		return err
+	}
+	return markdown.ValidateNotes(todo.Notes)
+}
+
+// bindRef reads the task to change from the body, addressed by ID or by
+// text among the tasks of today.
+func bindRef(c *gin.Context) (todos.Ref, bool) {
+	_, ref, ok := bindTodoRef(c)
+	return ref, ok
+}
+
+// bindTodoRef reads the body and the task to change it addresses.
+func bindTodoRef(c *gin.Context) (markdown.TodoItem, todos.Ref, bool) {
 	var todo markdown.TodoItem
 	if err := c.ShouldBindJSON(&todo); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+		return todo, todos.Ref{}, false
+	}
+	if todo.ID == "" && todo.Task == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing ID or task"})
+		return todo, todos.Ref{}, false
 	}
+	return todo, todos.Ref{ID: todo.ID, Task: todo.Task}, true
+}
 
-	if err := api.todoService.CompleteTodayTodo(todo.Task); err != nil {
-		logrus.Error(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add project"})
+func (api *RESTApiV1) CompleteTodayTodo(c *gin.Context) {
+	ref, ok := bindRef(c)
+	if !ok {
+		return
 	}
 
-	if err := api.timeTrackingService.CompleteTodayTimeTracking(todo.Task); err != nil {
-		logrus.Error(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to complete timetracking"})
+	// Todo and time tracking are changed in the same commit
+	var item *markdown.TodoItem
+	var timeTracking *markdown.TimeTrackingItem
+	message := todos.Message(todos.ActionComplete, ref.String(), "Complete task %s", ref)
+	err := api.ws.Mutate(c.Request.Context(), message, func(uow *workspace.UnitOfWork) error {
+		var err error
+		item, err = api.todoService.CompleteTask(uow, ref)
+		if err != nil {
+			return err
+		}
+		todos.Resolve(message, "Complete task %s", item)
+
+		timeTracking, err = api.stopTracking(uow, item)
+		return err
+	})
+	if err != nil {
+		respondError(c, err, "Failed to complete todo")
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"task": todo.Task,
+		"task":         item.Task,
+		"id":           item.ID,
+		"todo":         item,
+		"timeTracking": timeTracking,
 	})
 }
 
 func (api *RESTApiV1) StartTodayTodo(c *gin.Context) {
+	ref, ok := bindRef(c)
+	if !ok {
+		return
+	}
+
+	// Todo and time tracking are changed in the same commit
+	var item *markdown.TodoItem
+	var timeTracking *markdown.TimeTrackingItem
+	message := todos.Message(todos.ActionStart, ref.String(), "Start task %s", ref)
+	err := api.ws.Mutate(c.Request.Context(), message, func(uow *workspace.UnitOfWork) error {
+		var err error
+		item, err = api.todoService.StartTask(uow, ref)
+		if err != nil {
+			return err
+		}
+		todos.Resolve(message, "Start task %s", item)
+
+		timeTracking, err = api.timeTrackingService.StartTodo(uow, item)
+		return err
+	})
+	if err != nil {
+		respondError(c, err, "Failed to start todo")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"task":         item.Task,
+		"id":           item.ID,
+		"todo":         item,
+		"timeTracking": timeTracking,
+	})
+}
+
+// SetTodoStatus changes the status of a task, e.g. to cancelled, deferred
+// or blocked. Tracking is started for tasks in progress and stopped for all
+// others.
+func (api *RESTApiV1) SetTodoStatus(c *gin.Context) {
+	todo, ref, ok := bindTodoRef(c)
+	if !ok {
+		return
+	}
+	if !todo.Status.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid status %q, expected open, in_progress, done, cancelled, deferred or blocked", todo.Status)})
+		return
+	}
+
+	subject := fmt.Sprintf("Mark task %%s as %s", todo.Status)
+	var item *markdown.TodoItem
+	var timeTracking *markdown.TimeTrackingItem
+	message := todos.Message(todos.ActionStatus, ref.String(), subject, ref)
+	err := api.ws.Mutate(c.Request.Context(), message, func(uow *workspace.UnitOfWork) error {
+		var err error
+		item, err = api.todoService.SetTaskStatus(uow, ref, todo.Status)
+		if err != nil {
+			return err
+		}
+		todos.Resolve(message, subject, item)
+
+		if item.Status == markdown.StatusInProgress {
+			timeTracking, err = api.timeTrackingService.StartTodo(uow, item)
+		} else {
+			timeTracking, err = api.stopTracking(uow, item)
+		}
+		return err
+	})
+	if err != nil {
+		respondError(c, err, "Failed to change status of todo")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"task":         item.Task,
+		"id":           item.ID,
+		"status":       item.Status,
+		"todo":         item,
+		"timeTracking": timeTracking,
+	})
+}
+
+// stopTracking stops tracking the completed item and the parents it
+// completed, and returns the tracking of item.
+func (api *RESTApiV1) stopTracking(uow *workspace.UnitOfWork, item *markdown.TodoItem) (*markdown.TimeTrackingItem, error) {
+	timeTracking, err := api.timeTrackingService.CompleteTodayTask(uow, item.Task, item.ID)
+	if err != nil {
+		return nil, err
+	}
+	for parent := item.Parent(); parent != nil && parent.Status == markdown.StatusDone; parent = parent.Parent() {
+		if _, err := api.timeTrackingService.CompleteTodayTask(uow, parent.Task, parent.ID); err != nil {
+			return nil, err
+		}
+	}
+	return timeTracking, nil
+}
+
+// AddSubtask adds a subtask to the task with the ID of the path.
+func (api *RESTApiV1) AddSubtask(c *gin.Context) {
 	var todo markdown.TodoItem
 	if err := c.ShouldBindJSON(&todo); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if todo.Task == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing task"})
+		return
+	}
+	if err := validateTodo(todo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var item *markdown.TodoItem
+	message := todos.Message(todos.ActionAdd, todo.Task, "Add subtask %s", todo.Task)
+	err := api.ws.Mutate(c.Request.Context(), message, func(uow *workspace.UnitOfWork) error {
+		var err error
+		item, err = api.todoService.AddSubtask(uow, c.Param("id"), todo.Task, todo.Metadata, todo.Notes)
+		if err != nil {
+			return err
+		}
+		todos.Resolve(message, "Add subtask %s", item)
+		return nil
+	})
+	if err != nil {
+		respondError(c, err, "Failed to add subtask")
+		return
+	}
 
-	if err := api.todoService.StartTodayTodo(todo.Task); err != nil {
-		logrus.Error(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start todo"})
+	c.JSON(http.StatusOK, gin.H{
+		"task":   item.Task,
+		"id":     item.ID,
+		"parent": c.Param("id"),
+		"todo":   item,
+	})
+}
+
+// SetTodoNotes replaces the notes of the task with the ID of the path, an
+// empty text removes them.
+func (api *RESTApiV1) SetTodoNotes(c *gin.Context) {
+	var todo markdown.TodoItem
+	if err := c.ShouldBindJSON(&todo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := markdown.ValidateNotes(todo.Notes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	item, err := api.todoService.GetFullTask(todo.Task)
+	var item *markdown.TodoItem
+	message := todos.Message(todos.ActionNotes, c.Param("id"), "Edit notes of task %s", c.Param("id"))
+	err := api.ws.Mutate(c.Request.Context(), message, func(uow *workspace.UnitOfWork) error {
+		var err error
+		item, err = api.todoService.SetNotes(uow, c.Param("id"), todo.Notes)
+		if err != nil {
+			return err
+		}
+		todos.Resolve(message, "Edit notes of task %s", item)
+		return nil
+	})
 	if err != nil {
-		logrus.Error(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start todo"})
+		respondError(c, err, "Failed to edit notes")
+		return
 	}
-	if err := api.timeTrackingService.StartTodayTimeTracking(item.Task); err != nil {
-		logrus.Error(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start timetracking"})
+
+	c.JSON(http.StatusOK, gin.H{
+		"task":  item.Task,
+		"id":    item.ID,
+		"notes": item.Notes,
+		"todo":  item,
+	})
+}
+
+// CompleteSubtask completes a subtask of the task with the ID of the path,
+// addressed by ID or by text among its subtasks.
+func (api *RESTApiV1) CompleteSubtask(c *gin.Context) {
+	ref, ok := bindRef(c)
+	if !ok {
+		return
+	}
+
+	var item *markdown.TodoItem
+	var timeTracking *markdown.TimeTrackingItem
+	message := todos.Message(todos.ActionComplete, ref.String(), "Complete subtask %s", ref)
+	err := api.ws.Mutate(c.Request.Context(), message, func(uow *workspace.UnitOfWork) error {
+		var err error
+		item, err = api.todoService.CompleteSubtask(uow, c.Param("id"), ref)
+		if err != nil {
+			return err
+		}
+		todos.Resolve(message, "Complete subtask %s", item)
+
+		timeTracking, err = api.stopTracking(uow, item)
+		return err
+	})
+	if err != nil {
+		respondError(c, err, "Failed to complete subtask")
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"task": todo.Task,
+		"task":         item.Task,
+		"id":           item.ID,
+		"parent":       c.Param("id"),
+		"todo":         item,
+		"timeTracking": timeTracking,
 	})
 }
 
+// revisionQuery reads the revision to read lists from, given as rev or
+// as timestamp at. Dates include the whole day.
+func revisionQuery(c *gin.Context) (rev string, at time.Time, historic bool, err error) {
+	rev = c.Query("rev")
+	// Revisions are passed to git, options are refused
+	if strings.HasPrefix(rev, "-") {
+		return "", time.Time{}, false, fmt.Errorf("Invalid revision %s", rev)
+	}
+	at, err = parseTime(c.Query("at"), true)
+	if err != nil {
+		return "", time.Time{}, false, err
+	}
+	if rev != "" && !at.IsZero() {
+		return "", time.Time{}, false, fmt.Errorf("Only one of rev and at can be given")
+	}
+	return rev, at, rev != "" || !at.IsZero(), nil
+}
+
+// filterQuery reads the task filter given by tag, priority and due, which
+// matches tasks due on or before a day.
+func filterQuery(c *gin.Context) (markdown.TaskFilter, error) {
+	filter := markdown.TaskFilter{
+		Tag:      strings.TrimPrefix(c.Query("tag"), "#"),
+		Priority: markdown.Priority(c.Query("priority")),
+	}
+	if !filter.Priority.Valid() {
+		return filter, fmt.Errorf("Invalid priority %s, expected high, medium or low", filter.Priority)
+	}
+	due, err := parseTime(c.Query("due"), false)
+	if err != nil {
+		return filter, err
+	}
+	filter.DueBefore = due
+	return filter, nil
+}
+
+// GetTodaysTodos returns the todos of today, or the whole todo list at a
+// revision given by rev or at. Todos are filtered by tag, priority and
+// due, with scope=all among all tasks of the list.
 func (api *RESTApiV1) GetTodaysTodos(c *gin.Context) {
-	todos, err := api.todoService.GetTodaysTodos()
+	rev, at, historic, err := revisionQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter, err := filterQuery(c)
 	if err != nil {
-		logrus.Error(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch todays todos"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if historic {
+		list, rev, err := api.todoService.GetTodoListAt(c.Request.Context(), rev, at)
+		if err != nil {
+			respondError(c, err, "Failed to fetch todo list")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"data": list,
+			"rev":  rev,
+		})
+		return
+	}
+
+	var todos []*markdown.TodoItem
+	switch c.Query("scope") {
+	case "", "today":
+		todos, err = api.todoService.GetTodaysTodos(c.Request.Context(), filter)
+	case "all":
+		todos, err = api.todoService.FindTodos(c.Request.Context(), filter)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid scope %s, expected today or all", c.Query("scope"))})
+		return
+	}
+	if err != nil {
+		respondError(c, err, "Failed to fetch todays todos")
 		return
 	}
 
@@ -139,6 +583,173 @@ func (api *RESTApiV1) GetTodaysTodos(c *gin.Context) {
 	})
 }
 
+// GetTodaysTimeTrackings returns the time trackings of today, or the whole
+// time tracking list at a revision given by rev or at.
+func (api *RESTApiV1) GetTodaysTimeTrackings(c *gin.Context) {
+	rev, at, historic, err := revisionQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if historic {
+		list, rev, err := api.timeTrackingService.GetTimeTrackingListAt(c.Request.Context(), rev, at)
+		if err != nil {
+			respondError(c, err, "Failed to fetch time tracking list")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"data": list,
+			"rev":  rev,
+		})
+		return
+	}
+
+	items, err := api.timeTrackingService.GetTodaysTimeTrackings(c.Request.Context())
+	if err != nil {
+		respondError(c, err, "Failed to fetch todays time trackings")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": items,
+	})
+}
+
+func (api *RESTApiV1) GetSyncState(c *gin.Context) {
+	state, err := api.ws.SyncState(c.Request.Context())
+	if err != nil {
+		respondError(c, err, "Failed to get sync state")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": state,
+	})
+}
+
+func (api *RESTApiV1) Flush(c *gin.Context) {
+	if err := api.ws.Flush(c.Request.Context()); err != nil {
+		respondError(c, err, "Failed to flush pending changes")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"pending": api.ws.PendingChanges(),
+	})
+}
+
+// parseTime accepts RFC 3339 timestamps and dates, endOfDay moves dates
+// to the start of the next day so that they are included in a range.
+func parseTime(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid time %s, expected RFC 3339 or YYYY-MM-DD", value)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// defaultActivityLimit is the number of events returned by GetActivity
+// unless limit is given.
+const defaultActivityLimit = 100
+
+// GetActivity returns the newest changes of all tasks read from the
+// history of the repository, filterable by from, to, task and file. At
+// most limit events are returned, older ones are paged with to.
+func (api *RESTApiV1) GetActivity(c *gin.Context) {
+	from, err := parseTime(c.Query("from"), false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	to, err := parseTime(c.Query("to"), true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultActivityLimit)))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+		return
+	}
+
+	filter := activity.Filter{
+		From:  from,
+		To:    to,
+		Task:  c.Query("task"),
+		Limit: limit,
+	}
+	if file := c.Query("file"); file != "" {
+		known := false
+		for _, f := range activity.Files() {
+			known = known || f == file
+		}
+		if !known {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown file %s", file)})
+			return
+		}
+		filter.Files = []string{file}
+	}
+
+	ctx := c.Request.Context()
+	var events []*activity.Event
+	err = api.ws.ReadRepository(func(repo git.Repository) error {
+		var err error
+		events, err = activity.Feed(ctx, repo, filter)
+		return err
+	})
+	if err != nil {
+		respondError(c, err, "Failed to read activity")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": events,
+	})
+}
+
+// Undo reverts the last count changes made by the service in a single
+// commit, refusing to revert changes made by others unless force is set.
+func (api *RESTApiV1) Undo(c *gin.Context) {
+	count, err := strconv.Atoi(c.DefaultQuery("count", "1"))
+	if err != nil || count < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "count must be a positive number"})
+		return
+	}
+	force := c.Query("force") == "true"
+
+	reverted, err := api.todoService.Undo(c.Request.Context(), force, count)
+	if err != nil {
+		respondError(c, err, "Failed to undo")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reverted": reverted,
+	})
+}
+
+func (api *RESTApiV1) Redo(c *gin.Context) {
+	hash, err := api.todoService.Redo(c.Request.Context())
+	if err != nil {
+		respondError(c, err, "Failed to redo")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reverted": []string{hash},
+	})
+}
+
 /*
 func (api *RESTApiV1) GetProjects(c *gin.Context) {
 	projects, err := projects.GetService().GetAllProjects()
@@ -1,12 +1,21 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/martenwallewein/todo-service/api"
+	"github.com/martenwallewein/todo-service/pkg/auth"
 	"github.com/martenwallewein/todo-service/pkg/git"
+	"github.com/martenwallewein/todo-service/pkg/markdown"
+	"github.com/martenwallewein/todo-service/pkg/timetracking"
+	"github.com/martenwallewein/todo-service/pkg/todos"
+	"github.com/martenwallewein/todo-service/pkg/workspace"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -14,6 +23,21 @@ var (
 	laddr           = flag.String("addr", ":8880", "Local address for the HTTP API")
 	loglevel        = flag.String("loglevel", "TRACE", "Log-level (ERROR|WARN|INFO|DEBUG|TRACE)")
 	initialSeedFile = flag.String("initialSeedFile", "", "Run one-time seeds passing path to a valid JSON seed file")
+	gitTimeout      = flag.Duration("gitTimeout", time.Minute, "Kill git commands running longer than this")
+	gitBackend      = flag.String("gitBackend", git.BackendCLI, "Git backend to use (cli|memory), memory keeps the history until restart and can not use a remote")
+	callersFile     = flag.String("callersFile", "", "JSON file mapping bearer tokens to the name and email of callers, requires authentication if set")
+	trustHeaders    = flag.Bool("trustIdentityHeaders", false, "Use the caller identity of the X-Forwarded-User and X-Forwarded-Email headers")
+	attribution     = flag.String("attribution", workspace.AttributeAuthor, "Record the caller as commit author or as Co-authored-by trailer (author|trailer)")
+	carryOverGoals  = flag.Bool("carryOverGoals", false, "Copy unfinished goals of the previous month into a newly created month")
+	autoComplete    = flag.Bool("autoCompleteParents", false, "Complete a task once all of its subtasks are done")
+	plainTasks      = flag.Bool("plainTasks", false, "Write the tasks of a day as plain list instead of numbering them")
+	rollover        = flag.String("rollover", "", "Roll over unfinished tasks of past days on the first request of a day or on a schedule (request|schedule), only on request to the API if empty")
+	rolloverAt      = flag.Duration("rolloverAt", 0, "Time after midnight a scheduled rollover runs at (e.g. 6h)")
+	rolloverWeekend = flag.Bool("rolloverSkipWeekends", false, "Roll over no tasks on weekends and count only working days towards the maximum age")
+	rolloverMaxAge  = flag.Int("rolloverMaxAge", 0, "Leave tasks older than this many days behind on rollover, 0 rolls over tasks of any age")
+	rolloverTags    = flag.String("rolloverTags", "", "Comma separated tags, roll over only tasks with one of them")
+	writeBehind     = flag.Duration("writeBehind", 0, "Squash and push changes after this debounce window instead of pushing every change (e.g. 30s)")
+	syncInterval    = flag.Duration("syncInterval", time.Minute, "Fetch changes of others in background this often, reads are served from the local clone, 0 fetches only before changes")
 )
 
 func configureLogging() error {
@@ -57,21 +81,79 @@ func main() {
 		log.Fatal("Missing repo path")
 	}
 
-	// Repo does not exist yet
-	if _, err := os.Stat(path); err != nil {
+	repo, err := git.Open(context.Background(), git.Config{
+		Backend:               *gitBackend,
+		URL:                   repoUrl,
+		Path:                  path,
+		Timeout:               *gitTimeout,
+		Remote:                os.Getenv("TODO_REPO_REMOTE"),
+		Branch:                os.Getenv("TODO_REPO_BRANCH"),
+		AuthorName:            os.Getenv("TODO_REPO_AUTHOR_NAME"),
+		AuthorEmail:           os.Getenv("TODO_REPO_AUTHOR_EMAIL"),
+		SSHKeyPath:            os.Getenv("TODO_REPO_SSH_KEY"),
+		Token:                 os.Getenv("TODO_REPO_TOKEN"),
+		KnownHostsFile:        os.Getenv("TODO_REPO_KNOWN_HOSTS"),
+		StrictHostKeyChecking: os.Getenv("TODO_REPO_STRICT_HOST_KEY_CHECKING"),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	todos.RegisterMerger(repo)
+	timetracking.RegisterMerger(repo)
 
-		err := os.MkdirAll(path, 0775)
-		if err != nil {
-			log.Fatal("Failed to create base repo dir ", err)
-		}
-		_, err = git.Clone(repoUrl, path)
+	if *attribution != workspace.AttributeAuthor && *attribution != workspace.AttributeTrailer {
+		log.Fatalf("Unknown attribution %s", *attribution)
+	}
+
+	authConfig := auth.Config{
+		TrustHeaders: *trustHeaders,
+	}
+	if *callersFile != "" {
+		authConfig.Callers, err = auth.LoadCallers(*callersFile)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
 
-	api := api.NewRESTApiV1(path)
+	ws := workspace.New(repo, workspace.Config{
+		WriteBehind:  *writeBehind,
+		Attribution:  *attribution,
+		SyncInterval: *syncInterval,
+	})
+
+	// Flush pending changes on shutdown
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		log.Info("Shutting down, flushing pending changes")
+		ws.Close()
+		os.Exit(0)
+	}()
+
+	if *rollover != "" && *rollover != todos.RolloverRequest && *rollover != todos.RolloverSchedule {
+		log.Fatalf("Unknown rollover trigger %s", *rollover)
+	}
+	rolloverPolicy := markdown.RolloverPolicy{
+		SkipWeekends: *rolloverWeekend,
+		MaxAge:       *rolloverMaxAge,
+	}
+	for _, tag := range strings.Split(*rolloverTags, ",") {
+		if tag = strings.TrimPrefix(strings.TrimSpace(tag), "#"); tag != "" {
+			rolloverPolicy.Tags = append(rolloverPolicy.Tags, tag)
+		}
+	}
+
+	api := api.NewRESTApiV1(ws, authConfig, todos.Config{
+		CarryOverGoals:      *carryOverGoals,
+		AutoCompleteParents: *autoComplete,
+		PlainTasks:          *plainTasks,
+		Rollover:            rolloverPolicy,
+		RolloverTrigger:     *rollover,
+		RolloverAt:          *rolloverAt,
+	})
 	if err := api.Serve(*laddr); err != nil {
+		ws.Close()
 		log.Fatal(err)
 	}
 }
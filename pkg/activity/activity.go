@@ -0,0 +1,456 @@
+package activity
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/martenwallewein/todo-service/pkg/git"
+	"github.com/martenwallewein/todo-service/pkg/markdown"
+	"github.com/martenwallewein/todo-service/pkg/todos"
+	"github.com/sirupsen/logrus"
+)
+
+type Action string
+
+const (
+	Added     Action = "added"
+	Started   Action = "started"
+	Completed Action = "completed"
+	Reopened  Action = "reopened"
+	Cancelled Action = "cancelled"
+	Deferred  Action = "deferred"
+	Blocked   Action = "blocked"
+	Edited    Action = "edited"
+	Removed   Action = "removed"
+)
+
+// Event is a single change of a task, derived from two consecutive
+// versions of a file in the history of the repository.
+type Event struct {
+	Commit  string    `json:"commit"`
+	Subject string    `json:"subject"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Time    time.Time `json:"time"`
+	File    string    `json:"file"`
+	Action  Action    `json:"action"`
+	Task    string    `json:"task"`
+	// Previous is the task before it was edited
+	Previous string `json:"previous,omitempty"`
+	// Day the task is planned or tracked for
+	Day time.Time `json:"day"`
+}
+
+// Filter restricts the feed, zero values match everything.
+type Filter struct {
+	From time.Time
+	// To is exclusive, pages of the feed continue at the time of the
+	// oldest event returned
+	To time.Time
+	// Task matches all tasks containing it, ignoring case
+	Task string
+	// Files to read, all known files if empty
+	Files []string
+	// Limit is the number of newest events returned, 0 returns all
+	Limit int
+}
+
+func (f Filter) matchesTime(t time.Time) bool {
+	return (f.From.IsZero() || !t.Before(f.From)) && (f.To.IsZero() || t.Before(f.To))
+}
+
+func (f Filter) matchesTask(e *Event) bool {
+	if f.Task == "" {
+		return true
+	}
+	task := strings.ToLower(f.Task)
+	return strings.Contains(strings.ToLower(e.Task), task) || strings.Contains(strings.ToLower(e.Previous), task)
+}
+
+// change is an event without commit information.
+type change struct {
+	action   Action
+	task     string
+	previous string
+	day      time.Time
+}
+
+// flattener returns the entries of a version of a file, the content is
+// empty if the file did not exist.
+type flattener func(content []byte) ([]entry, error)
+
+var flatteners = map[string]flattener{
+	"todos.md":        todoEntries,
+	"timetracking.md": timeTrackingEntries,
+}
+
+// Files returns the files the feed can be built for.
+func Files() []string {
+	files := make([]string, 0, len(flatteners))
+	for file := range flatteners {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Feed walks the history of the files of filter and returns all events
+// matching filter, newest first.
+func Feed(ctx context.Context, repo git.Repository, filter Filter) ([]*Event, error) {
+	files := filter.Files
+	if len(files) == 0 {
+		files = Files()
+	}
+
+	events := []*Event{}
+	for _, file := range files {
+		flatten, ok := flatteners[file]
+		if !ok {
+			return nil, fmt.Errorf("No activity available for %s", file)
+		}
+
+		fileEvents, err := feed(ctx, repo, file, flatten, filter)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, fileEvents...)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.After(events[j].Time)
+	})
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[:filter.Limit]
+	}
+	return events, nil
+}
+
+// version is a version of a file read from the history.
+type version struct {
+	entries []entry
+	// err is set if the version could not be parsed
+	err error
+}
+
+func readVersion(ctx context.Context, repo git.Repository, rev, file string, flatten flattener) (*version, error) {
+	content, err := show(ctx, repo, rev, file)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := safeFlatten(flatten, content)
+	return &version{entries, err}, nil
+}
+
+// feed returns the events of file matching filter, newest first. Every
+// version of file is read and parsed at most once, the walk stops once
+// filter.Limit events are found or commits are older than filter.From.
+func feed(ctx context.Context, repo git.Repository, file string, flatten flattener, filter Filter) ([]*Event, error) {
+	commits, err := repo.Log(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []*Event{}
+	// Commits are ordered newest first, the previous version of a commit
+	// is the version of the next one
+	var next *version
+	for i, commit := range commits {
+		if filter.Limit > 0 && len(events) >= filter.Limit {
+			break
+		}
+		if !filter.From.IsZero() && commit.Time.Before(filter.From) {
+			break
+		}
+		if !filter.matchesTime(commit.Time) {
+			next = nil
+			continue
+		}
+
+		current := next
+		if current == nil {
+			current, err = readVersion(ctx, repo, commit.Hash, file, flatten)
+			if err != nil {
+				return nil, err
+			}
+		}
+		previous := &version{}
+		if i+1 < len(commits) {
+			previous, err = readVersion(ctx, repo, commits[i+1].Hash, file, flatten)
+			if err != nil {
+				return nil, err
+			}
+		}
+		next = previous
+
+		if current.err != nil || previous.err != nil {
+			logrus.Warnf("Skipping %s of %s: %v", file, commit.Hash, firstError(current.err, previous.err))
+			continue
+		}
+		changes := diffEntries(previous.entries, current.entries)
+
+		message := git.ParseMessage(commit.Message)
+		author := authorOf(commit, message)
+		for _, c := range changes {
+			event := &Event{
+				Commit:   commit.Hash,
+				Subject:  message.Subject,
+				Author:   author.Name,
+				Email:    author.Email,
+				Time:     commit.Time,
+				File:     file,
+				Action:   c.action,
+				Task:     c.task,
+				Previous: c.previous,
+				Day:      c.day,
+			}
+			if filter.matchesTask(event) {
+				events = append(events, event)
+			}
+		}
+	}
+	return events, nil
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authorOf returns who made the change of commit. Commits the service
+// made on behalf of a caller credit them with a Co-authored-by trailer,
+// the first one is preferred over the git author. Commits made by others
+// keep their git author, even if they credit co-authors.
+func authorOf(commit *git.Commit, message *git.Message) *git.Signature {
+	author := &git.Signature{Name: commit.Author, Email: commit.Email}
+	service := len(message.Get(todos.TrailerAction)) > 0 ||
+		commit.Author == git.DefaultAuthorName && commit.Email == git.DefaultAuthorEmail
+	if !service {
+		return author
+	}
+	for _, value := range message.Get(git.CoAuthoredBy) {
+		if coAuthor := git.ParseSignature(value); coAuthor != nil {
+			return coAuthor
+		}
+	}
+	return author
+}
+
+// safeFlatten runs flatten, hand edited versions the parsers choke on must
+// not break the whole feed.
+func safeFlatten(flatten flattener, content []byte) (entries []entry, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Failed to parse: %v", r)
+		}
+	}()
+
+	return flatten(content)
+}
+
+// show returns the content of file at rev, nil if file was removed.
+func show(ctx context.Context, repo git.Repository, rev, file string) ([]byte, error) {
+	content, err := repo.Show(ctx, rev, file)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		logrus.Debugf("No %s at %s: %v", file, rev, err)
+		return nil, nil
+	}
+	return content, nil
+}
+
+// entry is an item of a flattened list. Items sharing a slot replace
+// each other if edited.
+type entry struct {
+	// id of the item if assigned, matched before key
+	id     string
+	key    string
+	slot   string
+	task   string
+	day    time.Time
+	status markdown.Status
+	notes  string
+}
+
+// statusActions are the actions reported for a task changing to a status.
+var statusActions = map[markdown.Status]Action{
+	markdown.StatusDone:       Completed,
+	markdown.StatusInProgress: Started,
+	markdown.StatusCancelled:  Cancelled,
+	markdown.StatusDeferred:   Deferred,
+	markdown.StatusBlocked:    Blocked,
+}
+
+// diffEntries compares two flattened lists. Items are matched by ID, or
+// by key if the ID was not assigned yet. Items removed and added in the
+// same slot are reported as edited.
+func diffEntries(old, new []entry) []change {
+	oldByKey := map[string]entry{}
+	oldByID := map[string]entry{}
+	for _, e := range old {
+		oldByKey[e.key] = e
+		if e.id != "" {
+			oldByID[e.id] = e
+		}
+	}
+
+	matches := make([]*entry, len(new))
+	matched := map[string]bool{}
+	for i, e := range new {
+		before, ok := oldByID[e.id]
+		if !ok || e.id == "" {
+			before, ok = oldByKey[e.key]
+			ok = ok && (before.id == "" || before.id == e.id) && !matched[before.key]
+		}
+		if ok {
+			matches[i] = &before
+			matched[before.key] = true
+		}
+	}
+
+	removed := map[string][]entry{}
+	for _, e := range old {
+		if !matched[e.key] {
+			removed[e.slot] = append(removed[e.slot], e)
+		}
+	}
+
+	changes := []change{}
+	for i, e := range new {
+		before := matches[i]
+		if before == nil {
+			if candidates := removed[e.slot]; len(candidates) > 0 {
+				removed[e.slot] = candidates[1:]
+				changes = append(changes, change{Edited, e.task, candidates[0].task, e.day})
+			} else if action, ok := statusActions[e.status]; ok {
+				changes = append(changes, change{action, e.task, "", e.day})
+			} else {
+				changes = append(changes, change{Added, e.task, "", e.day})
+			}
+			continue
+		}
+
+		action, ok := statusActions[e.status]
+		switch {
+		case e.status != before.status && ok:
+			changes = append(changes, change{action, e.task, "", e.day})
+		case e.status != before.status && before.status != markdown.StatusInProgress:
+			changes = append(changes, change{Reopened, e.task, "", e.day})
+		case e.task != before.task:
+			changes = append(changes, change{Edited, e.task, before.task, e.day})
+		case e.notes != before.notes:
+			changes = append(changes, change{Edited, e.task, "", e.day})
+		}
+	}
+
+	for _, e := range old {
+		for _, r := range removed[e.slot] {
+			if r.key == e.key {
+				changes = append(changes, change{Removed, e.task, "", e.day})
+			}
+		}
+	}
+	return changes
+}
+
+// numbered makes the keys of entries unique by numbering duplicates.
+func numbered(entries []entry) []entry {
+	seen := map[string]int{}
+	for i := range entries {
+		seen[entries[i].key]++
+		if n := seen[entries[i].key]; n > 1 {
+			entries[i].key = fmt.Sprintf("%s#%d", entries[i].key, n)
+		}
+	}
+	return entries
+}
+
+func todoEntries(content []byte) ([]entry, error) {
+	if len(content) == 0 {
+		return nil, nil
+	}
+	tl, err := markdown.ParseMarkdownReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []entry{}
+	var add func(slot string, item *markdown.TodoItem)
+	add = func(slot string, item *markdown.TodoItem) {
+		entries = append(entries, entry{
+			id:     item.ID,
+			key:    fmt.Sprintf("%s|%s", slot, item.Task),
+			slot:   slot,
+			task:   item.Task,
+			day:    item.Day,
+			status: item.Status,
+			notes:  item.Notes,
+		})
+		// Subtasks are edited within their parent
+		for _, child := range item.Children {
+			add(fmt.Sprintf("%s|%s", slot, item.Task), child)
+		}
+	}
+	for _, item := range tl.Goals {
+		add("goals", item)
+	}
+	for _, m := range tl.Months {
+		if m == nil {
+			continue
+		}
+		month := m.Date.Format("2006-01")
+		for _, item := range m.Goals {
+			add(fmt.Sprintf("%s|goals", month), item)
+		}
+		for _, item := range m.Items {
+			add(fmt.Sprintf("%s|%s", month, item.Day.Format("2006-01-02")), item)
+		}
+	}
+	return numbered(entries), nil
+}
+
+// trackingStatus returns whether the tracking of item is running or done.
+func trackingStatus(item *markdown.TimeTrackingItem) markdown.Status {
+	if item.InProgress {
+		return markdown.StatusInProgress
+	}
+	return markdown.StatusDone
+}
+
+func timeTrackingEntries(content []byte) ([]entry, error) {
+	if len(content) == 0 {
+		return nil, nil
+	}
+	tl, err := markdown.ParseTimeTrackingMarkdownReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []entry{}
+	for _, m := range tl.Months {
+		if m == nil {
+			continue
+		}
+		for _, item := range m.Items {
+			slot := item.Start.Format("2006-01-02T15:04")
+			entries = append(entries, entry{
+				id:     item.ID,
+				key:    fmt.Sprintf("%s|%s", slot, item.Task),
+				slot:   slot,
+				task:   item.Task,
+				day:    item.Start,
+				status: trackingStatus(item),
+			})
+		}
+	}
+	return numbered(entries), nil
+}
@@ -0,0 +1,121 @@
+package activity
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/martenwallewein/todo-service/pkg/git"
+	"github.com/martenwallewein/todo-service/pkg/todos"
+)
+
+const todoList = `# Todos
+
+## 01/2023
+- todos:
+    - 03.01
+        - [ ] 1) a
+`
+
+func TestFeedCreditsCoAuthor(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo, err := git.NewMemoryRepo(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.SetIdentity("Alex", "alex@example.com")
+
+	commit := func(content, message string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, "todos.md"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.CommitAll(ctx, message, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commit(todoList, "Add a")
+	done := strings.Replace(todoList, "[ ] 1) a", "[x] 1) a", 1)
+	// Made by the service for Jane
+	commit(done, todos.Message(todos.ActionComplete, "a", "Complete a").With(git.CoAuthoredBy, "Jane Doe <jane@example.com>").String())
+	// Made by hand while pairing
+	commit(todoList, git.NewMessage("Reopen a").With(git.CoAuthoredBy, "Sam <sam@example.com>").String())
+
+	events, err := Feed(ctx, repo, Filter{Files: []string{"todos.md"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authors := map[Action]string{}
+	for _, e := range events {
+		authors[e.Action] = e.Author + " <" + e.Email + ">"
+	}
+	want := map[Action]string{
+		Added:     "Alex <alex@example.com>",
+		Completed: "Jane Doe <jane@example.com>",
+		Reopened:  "Alex <alex@example.com>",
+	}
+	for action, author := range want {
+		if authors[action] != author {
+			t.Errorf("%s by %q, want %q", action, authors[action], author)
+		}
+	}
+}
+
+// countingRepo counts the versions shown.
+type countingRepo struct {
+	git.Repository
+	shown map[string]int
+}
+
+func (r *countingRepo) Show(ctx context.Context, rev, file string) ([]byte, error) {
+	r.shown[rev]++
+	return r.Repository.Show(ctx, rev, file)
+}
+
+func TestFeedReadsVersionsOnceAndStopsAtLimit(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	memory, err := git.NewMemoryRepo(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := todoList
+	for _, task := range []string{"b", "c", "d", "e"} {
+		content += "        - [ ] " + task + "\n"
+		if err := os.WriteFile(filepath.Join(dir, "todos.md"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := memory.CommitAll(ctx, "Add "+task, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo := &countingRepo{memory, map[string]int{}}
+
+	events, err := Feed(ctx, repo, Filter{Files: []string{"todos.md"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 5 {
+		t.Errorf("feed has %d events, want 5", len(events))
+	}
+	for rev, n := range repo.shown {
+		if n > 1 {
+			t.Errorf("%s shown %d times", rev, n)
+		}
+	}
+
+	repo.shown = map[string]int{}
+	events, err = Feed(ctx, repo, Filter{Files: []string{"todos.md"}, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Task != "e" || events[1].Task != "d" {
+		t.Errorf("feed with limit 2 has %v, want e and d", events)
+	}
+	if len(repo.shown) != 3 {
+		t.Errorf("%d versions shown for 2 events, want 3", len(repo.shown))
+	}
+}
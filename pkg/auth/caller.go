@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/martenwallewein/todo-service/pkg/git"
+)
+
+type callerKey struct{}
+
+// WithCaller returns a copy of ctx carrying the identity of the caller.
+func WithCaller(ctx context.Context, caller *git.Signature) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFrom returns the identity of the caller stored in ctx, nil if the
+// request was not authenticated.
+func CallerFrom(ctx context.Context) *git.Signature {
+	caller, _ := ctx.Value(callerKey{}).(*git.Signature)
+	return caller
+}
+
+// Callers maps bearer tokens to the identity of their owner.
+type Callers map[string]*git.Signature
+
+// LoadCallers reads a JSON file of the form
+// {"<token>": {"name": "Jane Doe", "email": "jane@example.com"}}.
+func LoadCallers(file string) (Callers, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("Failed to parse callers file %s: %w", file, err)
+	}
+
+	callers := Callers{}
+	for token, entry := range entries {
+		if entry.Name == "" || entry.Email == "" {
+			return nil, fmt.Errorf("Caller of callers file %s is missing name or email", file)
+		}
+		callers[token] = &git.Signature{Name: entry.Name, Email: entry.Email}
+	}
+	return callers, nil
+}
+
+type Config struct {
+	// Callers authenticated by bearer token, if not empty every request
+	// must be authenticated
+	Callers Callers
+	// TrustHeaders uses the X-Forwarded-User and X-Forwarded-Email headers
+	// set by an authenticating proxy
+	TrustHeaders bool
+}
+
+func (c Config) required() bool {
+	return len(c.Callers) > 0
+}
+
+// Middleware stores the identity of the caller in the request context.
+func Middleware(config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var caller *git.Signature
+
+		if token := bearerToken(c.GetHeader("Authorization")); token != "" {
+			caller = config.Callers[token]
+			if caller == nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+				return
+			}
+		} else if config.TrustHeaders {
+			name := c.GetHeader("X-Forwarded-User")
+			email := c.GetHeader("X-Forwarded-Email")
+			if name != "" && email != "" {
+				caller = &git.Signature{Name: name, Email: email}
+			}
+		}
+
+		if caller == nil && config.required() {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing caller identity"})
+			return
+		}
+
+		if caller != nil {
+			c.Request = c.Request.WithContext(WithCaller(c.Request.Context(), caller))
+		}
+		c.Next()
+	}
+}
+
+func bearerToken(header string) string {
+	const prefix = "Bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(prefix):])
+}
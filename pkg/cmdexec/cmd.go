@@ -2,30 +2,65 @@ package cmdexec
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
 	"os/exec"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
-func Exec(command string, args ...string) (error, string, string) {
-	cmd := exec.Command(command, args...)
-	var out bytes.Buffer
-	var stdErr bytes.Buffer
-	cmd.Stderr = &stdErr
-	cmd.Stdout = &out
-	logrus.Tracef("Executing: %s\n", cmd.String())
-	err := cmd.Run()
-	if err == nil {
-		logrus.Tracef("Execute successful")
-	} else {
-		logrus.Tracef("Execute failed %s", err.Error())
+// ExecError is returned if a command could not be run or exited with a
+// non-zero exit code.
+type ExecError struct {
+	Command  string
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	TimedOut bool
+	Err      error
+}
+
+func (e *ExecError) Error() string {
+	if e.TimedOut {
+		return fmt.Sprintf("%s timed out: %s", e.Command, strings.TrimSpace(e.Stderr))
 	}
-	return err, out.String(), stdErr.String()
+	if e.ExitCode < 0 {
+		return fmt.Sprintf("%s failed: %s", e.Command, e.Err)
+	}
+	return fmt.Sprintf("%s exited with code %d: %s", e.Command, e.ExitCode, strings.TrimSpace(e.Stderr))
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.Err
 }
 
-func ExecInFolder(folder, command string, args ...string) (error, string, string) {
-	cmd := exec.Command(command, args...)
-	cmd.Dir = folder
+type Options struct {
+	// Dir is the working directory, the current one if empty
+	Dir string
+	// Env is added to the environment of the current process
+	Env []string
+	// Timeout kills the command after the given duration if not zero
+	Timeout time.Duration
+}
+
+// Run runs command with args until it exits, ctx is done or the timeout
+// expires and returns its stdout.
+func Run(ctx context.Context, opts Options, command string, args ...string) (string, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Dir = opts.Dir
+	if len(opts.Env) > 0 {
+		cmd.Env = append(os.Environ(), opts.Env...)
+	}
 	var out bytes.Buffer
 	var stdErr bytes.Buffer
 	cmd.Stderr = &stdErr
@@ -34,8 +69,32 @@ func ExecInFolder(folder, command string, args ...string) (error, string, string
 	err := cmd.Run()
 	if err == nil {
 		logrus.Tracef("Execute successful")
-	} else {
-		logrus.Tracef("Execute failed %s", err.Error())
+		return out.String(), nil
+	}
+
+	logrus.Tracef("Execute failed %s", err.Error())
+	execErr := &ExecError{
+		Command:  cmd.String(),
+		ExitCode: -1,
+		Stdout:   out.String(),
+		Stderr:   stdErr.String(),
+		Err:      err,
 	}
-	return err, out.String(), stdErr.String()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		execErr.TimedOut = errors.Is(ctxErr, context.DeadlineExceeded)
+		execErr.Err = ctxErr
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		execErr.ExitCode = exitErr.ExitCode()
+	}
+	return execErr.Stdout, execErr
+}
+
+func Exec(ctx context.Context, command string, args ...string) (string, error) {
+	return Run(ctx, Options{}, command, args...)
+}
+
+func ExecInFolder(ctx context.Context, folder, command string, args ...string) (string, error) {
+	return Run(ctx, Options{Dir: folder}, command, args...)
 }
@@ -1,27 +1,153 @@
 package git
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/martenwallewein/todo-service/pkg/cmdexec"
 	"github.com/sirupsen/logrus"
 )
 
+// GitRepo implements Repository using the git binary.
 type GitRepo struct {
 	Path string
+	// Timeout limits the runtime of every git command if not zero
+	Timeout time.Duration
+	// Remote and Branch to fetch from and push to, the configured
+	// upstream of the current branch if Branch is empty
+	Remote  string
+	Branch  string
+	env     []string
+	mergers map[string]MergeFunc
 }
 
-func Clone(url string, path string) (*GitRepo, error) {
-	err, _, errStr := cmdexec.Exec("git", "clone", url, path)
+// maxRebaseSteps limits the number of commits resolved in one rebase
+const maxRebaseSteps = 100
+
+func (r *GitRepo) git(ctx context.Context, args ...string) (string, error) {
+	return cmdexec.Run(ctx, cmdexec.Options{
+		Dir:     r.Path,
+		Env:     r.env,
+		Timeout: r.Timeout,
+	}, "git", args...)
+}
+
+// configure applies the per repository settings of config.
+func (r *GitRepo) configure(config Config) {
+	r.Timeout = config.Timeout
+	r.Remote = config.remote()
+	r.Branch = config.Branch
+	r.env = config.env()
+}
+
+// Identity of commits if neither Config nor the git config set one
+const (
+	DefaultAuthorName  = "martengartnerbot"
+	DefaultAuthorEmail = "bot@martengartner.com"
+)
+
+// defaultIdentity falls back to DefaultAuthorName and DefaultAuthorEmail
+// for the parts of the identity that are not configured, so that commits
+// do not fail with an unknown identity.
+func (r *GitRepo) defaultIdentity(ctx context.Context, config Config) {
+	defaults := []struct {
+		set   string
+		key   string
+		value string
+		vars  []string
+	}{
+		{config.AuthorName, "user.name", DefaultAuthorName, []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"}},
+		{config.AuthorEmail, "user.email", DefaultAuthorEmail, []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"}},
+	}
+	for _, d := range defaults {
+		if d.set != "" {
+			continue
+		}
+		if out, err := r.git(ctx, "config", "--get", d.key); err == nil && strings.TrimSpace(out) != "" {
+			continue
+		}
+		logrus.Warnf("No git %s configured, committing as %s", d.key, d.value)
+		for _, v := range d.vars {
+			r.env = append(r.env, v+"="+d.value)
+		}
+	}
+}
+
+// upstream returns the ref local commits are rebased onto.
+func (r *GitRepo) upstream() string {
+	if r.Branch == "" {
+		return "@{upstream}"
+	}
+	return fmt.Sprintf("%s/%s", r.Remote, r.Branch)
+}
+
+// Clone clones config.URL into config.Path.
+func Clone(ctx context.Context, config Config) (*GitRepo, error) {
+	args := []string{"clone", "--origin", config.remote()}
+	if config.Branch != "" {
+		args = append(args, "--branch", config.Branch)
+	}
+	args = append(args, config.URL, config.Path)
+	_, err := cmdexec.Run(ctx, cmdexec.Options{
+		Env:     config.env(),
+		Timeout: config.Timeout,
+	}, "git", args...)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to clone git repo: %s", errStr)
+		return nil, fmt.Errorf("Failed to clone git repo: %w", err)
 	}
 
-	return loadFromPath(path)
+	repo, err := loadFromPath(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	repo.configure(config)
+	return repo, nil
+}
+
+// excludeTempFiles keeps temporary files left behind by a crash out of
+// commits, without touching the .gitignore of the repository.
+func (r *GitRepo) excludeTempFiles(ctx context.Context) error {
+	out, err := r.git(ctx, "rev-parse", "--git-path", "info/exclude")
+	if err != nil {
+		return fmt.Errorf("Failed to find exclude file: %w", err)
+	}
+	file := strings.TrimSpace(out)
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(r.Path, file)
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == TempFilePattern {
+			return nil
+		}
+	}
 
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	line := TempFilePattern + "\n"
+	if len(content) > 0 && !strings.HasSuffix(string(content), "\n") {
+		line = "\n" + line
+	}
+	_, err = f.WriteString(line)
+	return err
 }
 
 func loadFromPath(path string) (*GitRepo, error) {
@@ -74,37 +200,303 @@ func Load(repo string) (*GitRepo, error) {
 	return loadFromPath(repo)
 }
 
-func (r *GitRepo) FetchAndRebase() error {
+func (r *GitRepo) WorkDir() string {
+	return r.Path
+}
 
-	err, _, errStr := cmdexec.ExecInFolder(r.Path, "git", "fetch")
+func (r *GitRepo) FetchAndRebase(ctx context.Context) error {
+	err := r.Fetch(ctx)
 	if err != nil {
-		return fmt.Errorf("Failed to fetch git repo: %s", errStr)
+		return err
 	}
-	err, _, errStr = cmdexec.ExecInFolder(r.Path, "git", "rebase")
+
+	return r.Rebase(ctx)
+}
+
+func (r *GitRepo) Fetch(ctx context.Context) error {
+	_, err := r.git(ctx, "fetch", r.Remote)
 	if err != nil {
-		return fmt.Errorf("Failed to rebase git repo: %s", errStr)
+		return fmt.Errorf("Failed to fetch git repo: %w", err)
+	}
+	return nil
+}
+
+// RegisterMerger uses fn to resolve conflicts of file, relative to the
+// root of the repository, during a rebase.
+func (r *GitRepo) RegisterMerger(file string, fn MergeFunc) {
+	if r.mergers == nil {
+		r.mergers = map[string]MergeFunc{}
+	}
+	r.mergers[file] = fn
+}
+
+// Rebase rebases local commits onto the upstream branch. Conflicts in
+// files with a registered merger are resolved, otherwise the rebase is
+// aborted so that the working tree is always left clean.
+func (r *GitRepo) Rebase(ctx context.Context) error {
+	// Clean up after a rebase that was interrupted earlier
+	if r.rebaseInProgress() {
+		logrus.Warn("Aborting unfinished rebase")
+		if err := r.abortRebase(ctx); err != nil {
+			return err
+		}
 	}
 
+	_, err := r.git(ctx, "rebase", r.upstream())
+	for i := 0; err != nil; i++ {
+		if !r.rebaseInProgress() {
+			return fmt.Errorf("Failed to rebase git repo: %w", err)
+		}
+
+		if i >= maxRebaseSteps {
+			err = fmt.Errorf("Failed to rebase git repo: too many steps")
+		} else if err = r.resolveConflicts(ctx); err != nil {
+			err = fmt.Errorf("Failed to rebase git repo: %w", err)
+		}
+		if err != nil {
+			if abortErr := r.abortRebase(ctx); abortErr != nil {
+				logrus.Error(abortErr)
+			}
+			return err
+		}
+
+		_, err = r.git(ctx, "rebase", "--continue")
+	}
 	return nil
 }
 
-func (r *GitRepo) CommitAll(message string) error {
-	err, _, errStr := cmdexec.ExecInFolder(r.Path, "git", "add", ".")
+func (r *GitRepo) rebaseInProgress() bool {
+	for _, dir := range []string{"rebase-merge", "rebase-apply"} {
+		if _, err := os.Stat(filepath.Join(r.Path, ".git", dir)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *GitRepo) abortRebase(ctx context.Context) error {
+	_, err := r.git(ctx, "rebase", "--abort")
 	if err != nil {
-		return fmt.Errorf("Failed to add files to git repo: %s", errStr)
+		return fmt.Errorf("Failed to abort rebase of git repo: %w", err)
 	}
-	err, _, errStr = cmdexec.ExecInFolder(r.Path, "git", "commit", "-m", fmt.Sprintf("'%s'", message))
+	return nil
+}
+
+// resolveConflicts merges all conflicting files of the current rebase
+// step or revert using the registered mergers.
+func (r *GitRepo) resolveConflicts(ctx context.Context) error {
+	out, err := r.git(ctx, "diff", "--name-only", "--diff-filter=U")
 	if err != nil {
-		return fmt.Errorf("Failed to commit to git repo: %s", errStr)
+		return fmt.Errorf("Failed to list conflicts of git repo: %w", err)
+	}
+
+	files := strings.Fields(out)
+	if len(files) == 0 {
+		return fmt.Errorf("No conflicting files to resolve")
 	}
 
+	for _, file := range files {
+		merge, ok := r.mergers[file]
+		if !ok {
+			return fmt.Errorf("%w in %s can not be merged automatically", ErrConflict, file)
+		}
+
+		// A missing base means the file was added on both sides
+		base, _ := r.showStage(ctx, 1, file)
+		ours, err := r.showStage(ctx, 2, file)
+		if err != nil {
+			return err
+		}
+		theirs, err := r.showStage(ctx, 3, file)
+		if err != nil {
+			return err
+		}
+
+		merged, err := merge(base, ours, theirs)
+		if err != nil {
+			return err
+		}
+
+		err = ioutil.WriteFile(filepath.Join(r.Path, file), merged, 0644)
+		if err != nil {
+			return err
+		}
+
+		_, err = r.git(ctx, "add", file)
+		if err != nil {
+			return fmt.Errorf("Failed to add merged %s to git repo: %w", file, err)
+		}
+		logrus.Infof("Merged conflicting changes of %s", file)
+	}
+	return nil
+}
+
+func (r *GitRepo) showStage(ctx context.Context, stage int, file string) ([]byte, error) {
+	out, err := r.git(ctx, "show", fmt.Sprintf(":%d:%s", stage, file))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to show stage %d of %s: %w", stage, file, err)
+	}
+	return []byte(out), nil
+}
+
+func (r *GitRepo) CommitAll(ctx context.Context, message string, author *Signature) error {
+	_, err := r.git(ctx, "add", ".")
+	if err != nil {
+		return fmt.Errorf("Failed to add files to git repo: %w", err)
+	}
+	args := []string{"commit", "-m", message}
+	if author != nil {
+		args = append(args, "--author", author.String())
+	}
+	_, err = r.git(ctx, args...)
+	if err != nil {
+		return fmt.Errorf("Failed to commit to git repo: %w", err)
+	}
+
+	return nil
+}
+
+// Revert applies the inverse changes of rev to the working tree without
+// committing them. On failure the working tree is left clean.
+func (r *GitRepo) Revert(ctx context.Context, rev string) error {
+	_, err := r.git(ctx, "revert", "--no-commit", rev)
+	if err == nil {
+		return nil
+	}
+
+	out, diffErr := r.git(ctx, "diff", "--name-only", "--diff-filter=U")
+	if diffErr == nil && strings.TrimSpace(out) != "" {
+		err = r.resolveConflicts(ctx)
+		if err == nil {
+			return nil
+		}
+	}
+
+	if _, abortErr := r.git(ctx, "revert", "--abort"); abortErr != nil {
+		logrus.Error(abortErr)
+	}
+	return fmt.Errorf("Failed to revert %s: %w", rev, err)
+}
+
+func (r *GitRepo) Push(ctx context.Context) error {
+	args := []string{"push", r.Remote}
+	if r.Branch != "" {
+		args = append(args, fmt.Sprintf("HEAD:%s", r.Branch))
+	}
+	_, err := r.git(ctx, args...)
+	if err != nil {
+		return fmt.Errorf("Failed to push git repo: %w", err)
+	}
 	return nil
 }
 
-func (r *GitRepo) Push() error {
-	err, _, errStr := cmdexec.ExecInFolder(r.Path, "git", "push")
+func (r *GitRepo) Head(ctx context.Context) (string, error) {
+	out, err := r.git(ctx, "rev-parse", "HEAD")
 	if err != nil {
-		return fmt.Errorf("Failed to push git repo: %s", errStr)
+		return "", fmt.Errorf("Failed to resolve HEAD of git repo: %w", err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (r *GitRepo) ResetHard(ctx context.Context, rev string) error {
+	_, err := r.git(ctx, "reset", "--hard", rev)
+	if err != nil {
+		return fmt.Errorf("Failed to reset git repo to %s: %w", rev, err)
 	}
 	return nil
 }
+
+func (r *GitRepo) HasChanges(ctx context.Context) (bool, error) {
+	out, err := r.git(ctx, "status", "--porcelain")
+	if err != nil {
+		return false, fmt.Errorf("Failed to get status of git repo: %w", err)
+	}
+	return strings.TrimSpace(out) != "", nil
+}
+
+func (r *GitRepo) ResetSoft(ctx context.Context, rev string) error {
+	_, err := r.git(ctx, "reset", "--soft", rev)
+	if err != nil {
+		return fmt.Errorf("Failed to soft reset git repo to %s: %w", rev, err)
+	}
+	return nil
+}
+
+// MergeBase returns the best common ancestor of HEAD and its upstream.
+func (r *GitRepo) MergeBase(ctx context.Context) (string, error) {
+	out, err := r.git(ctx, "merge-base", "HEAD", r.upstream())
+	if err != nil {
+		return "", fmt.Errorf("Failed to find merge base of git repo: %w", err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// AheadBehind returns the number of commits HEAD is ahead and behind of
+// its upstream, as of the last fetch.
+func (r *GitRepo) AheadBehind(ctx context.Context) (int, int, error) {
+	out, err := r.git(ctx, "rev-list", "--left-right", "--count", fmt.Sprintf("HEAD...%s", r.upstream()))
+	if err != nil {
+		return 0, 0, fmt.Errorf("Failed to compare git repo with upstream: %w", err)
+	}
+
+	parts := strings.Fields(out)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Unexpected output comparing git repo with upstream: %s", out)
+	}
+	ahead, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	behind, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return ahead, behind, nil
+}
+
+func (r *GitRepo) Log(ctx context.Context, files ...string) ([]*Commit, error) {
+	args := []string{"log", "--format=%H%x1f%an%x1f%ae%x1f%aI%x1f%B%x1e", "--"}
+	args = append(args, files...)
+	out, err := r.git(ctx, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read log of git repo: %w", err)
+	}
+
+	commits := []*Commit{}
+	for _, record := range strings.Split(out, "\x1e") {
+		fields := strings.Split(strings.TrimLeft(record, "\n"), "\x1f")
+		if len(fields) != 5 {
+			continue
+		}
+		date, err := time.Parse(time.RFC3339, fields[3])
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, &Commit{
+			Hash:    fields[0],
+			Author:  fields[1],
+			Email:   fields[2],
+			Time:    date,
+			Message: strings.TrimSpace(fields[4]),
+		})
+	}
+	return commits, nil
+}
+
+// Show resolves rev to a commit first, so that a rev like --output=file
+// is never read as an option of git show.
+func (r *GitRepo) Show(ctx context.Context, rev string, file string) ([]byte, error) {
+	out, err := r.git(ctx, "rev-parse", "--verify", "--end-of-options", rev+"^{commit}")
+	if err == nil {
+		out, err = r.git(ctx, "show", fmt.Sprintf("%s:%s", strings.TrimSpace(out), file))
+	}
+	if err != nil {
+		// git exits with 128 for unknown revisions and paths
+		var execErr *cmdexec.ExecError
+		if errors.As(err, &execErr) && execErr.ExitCode == 128 {
+			return nil, fmt.Errorf("Failed to show %s of %s: %w", file, rev, ErrNotFound)
+		}
+		return nil, fmt.Errorf("Failed to show %s of %s: %w", file, rev, err)
+	}
+	return []byte(out), nil
+}
@@ -0,0 +1,72 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestShowRefusesOptions(t *testing.T) {
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=Test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	repo, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(dir, "written")
+	_, err = repo.Show(context.Background(), "--output="+target, "todos.md")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Show returned %v, want ErrNotFound", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("Show created %s", target)
+	}
+}
+
+func TestCommitWithoutIdentity(t *testing.T) {
+	// No global or system git config
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	dir := t.TempDir()
+	if out, err := exec.Command("git", "-C", dir, "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "todos.md"), []byte("## 01/2023\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	repo, err := Open(ctx, Config{Path: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CommitAll(ctx, "Add todos", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "todos.md"), []byte("## 02/2023\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CommitAll(ctx, "Change todos", &Signature{"Caller", "caller@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	commits, err := repo.Log(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 2 || commits[0].Author != "Caller" || commits[1].Author != DefaultAuthorName || commits[1].Email != DefaultAuthorEmail {
+		t.Errorf("commits %+v %+v", commits[0], commits[1])
+	}
+}
@@ -0,0 +1,599 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const memoryAuthor = "todo-service"
+
+type memoryCommit struct {
+	Commit
+	parent string
+	files  map[string][]byte
+}
+
+// MemoryRemote is an in-process remote that MemoryRepos can fetch from
+// and push to, e.g. to test several clones against each other.
+type MemoryRemote struct {
+	mu      sync.Mutex
+	commits map[string]*memoryCommit
+	head    string
+}
+
+func NewMemoryRemote() *MemoryRemote {
+	return &MemoryRemote{
+		commits: map[string]*memoryCommit{},
+	}
+}
+
+// MemoryRepo implements Repository without the git binary. The working
+// tree lives on disk, the history is only kept in memory. Without a
+// remote, pushing just moves the upstream branch.
+type MemoryRepo struct {
+	mu       sync.Mutex
+	path     string
+	remote   *MemoryRemote
+	commits  map[string]*memoryCommit
+	head     string
+	upstream string
+	mergers  map[string]MergeFunc
+	author   string
+	email    string
+}
+
+// NewMemoryRepo opens a memory repository with its working tree at path.
+// With a remote, the working tree is checked out from it, otherwise the
+// existing files at path become the first commit.
+func NewMemoryRepo(path string, remote *MemoryRemote) (*MemoryRepo, error) {
+	err := os.MkdirAll(path, 0775)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create base repo dir: %s", err)
+	}
+
+	r := &MemoryRepo{
+		path:    path,
+		remote:  remote,
+		commits: map[string]*memoryCommit{},
+		mergers: map[string]MergeFunc{},
+		author:  memoryAuthor,
+	}
+
+	if remote != nil {
+		r.fetch()
+		r.head = r.upstream
+		return r, r.checkout("", r.head)
+	}
+
+	files, err := r.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		r.head = r.commit("", "Import working tree", files)
+		r.upstream = r.head
+	}
+	return r, nil
+}
+
+// SetIdentity sets the author of new commits, empty values are ignored.
+func (r *MemoryRepo) SetIdentity(name, email string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if name != "" {
+		r.author = name
+	}
+	if email != "" {
+		r.email = email
+	}
+}
+
+func (r *MemoryRepo) WorkDir() string {
+	return r.path
+}
+
+func (r *MemoryRepo) RegisterMerger(file string, fn MergeFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mergers[file] = fn
+}
+
+// snapshot reads all files of the working tree.
+func (r *MemoryRepo) snapshot() (map[string][]byte, error) {
+	files := map[string][]byte{}
+	err := filepath.Walk(r.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if temp, _ := filepath.Match(TempFilePattern, info.Name()); temp {
+			return nil
+		}
+		rel, err := filepath.Rel(r.path, path)
+		if err != nil {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = content
+		return nil
+	})
+	return files, err
+}
+
+// checkout replaces the files of commit from with the files of commit to
+// in the working tree.
+func (r *MemoryRepo) checkout(from, to string) error {
+	return r.writeTree(r.files(from), r.files(to))
+}
+
+// writeTree replaces the files old with files in the working tree.
+func (r *MemoryRepo) writeTree(old, files map[string][]byte) error {
+	for name := range old {
+		if _, ok := files[name]; !ok {
+			err := os.Remove(filepath.Join(r.path, filepath.FromSlash(name)))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	for name, content := range files {
+		file := filepath.Join(r.path, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(file), 0775)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(file, content, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepo) files(rev string) map[string][]byte {
+	if c, ok := r.commits[rev]; ok {
+		return c.files
+	}
+	return map[string][]byte{}
+}
+
+func (r *MemoryRepo) commit(parent string, message string, files map[string][]byte) string {
+	return r.commitAs(parent, message, r.author, r.email, files)
+}
+
+func (r *MemoryRepo) commitAs(parent string, message string, author string, email string, files map[string][]byte) string {
+	now := time.Now()
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\n%s\n%d\n", parent, message, now.UnixNano())
+	for _, name := range names {
+		fmt.Fprintf(h, "%s\n%d\n", name, len(files[name]))
+		h.Write(files[name])
+	}
+	hash := fmt.Sprintf("%x", h.Sum(nil))
+
+	r.commits[hash] = &memoryCommit{
+		Commit: Commit{
+			Hash:    hash,
+			Author:  author,
+			Email:   email,
+			Time:    now,
+			Message: message,
+		},
+		parent: parent,
+		files:  files,
+	}
+	return hash
+}
+
+// ancestors returns rev and all its ancestors known locally, newest
+// first.
+func (r *MemoryRepo) ancestors(rev string) []string {
+	chain := []string{}
+	for rev != "" {
+		c, ok := r.commits[rev]
+		if !ok {
+			break
+		}
+		chain = append(chain, rev)
+		rev = c.parent
+	}
+	return chain
+}
+
+func (r *MemoryRepo) mergeBase(a, b string) string {
+	inA := map[string]bool{}
+	for _, rev := range r.ancestors(a) {
+		inA[rev] = true
+	}
+	for _, rev := range r.ancestors(b) {
+		if inA[rev] {
+			return rev
+		}
+	}
+	return ""
+}
+
+// count returns the number of commits reachable from rev but not base.
+func (r *MemoryRepo) count(rev, base string) int {
+	n := 0
+	for _, c := range r.ancestors(rev) {
+		if c == base {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// resolve resolves HEAD, full or abbreviated hashes, optionally followed
+// by ~n.
+func (r *MemoryRepo) resolve(rev string) (string, error) {
+	// The empty revision of an empty repository
+	if rev == "" {
+		return "", nil
+	}
+
+	parents := 0
+	if i := strings.Index(rev, "~"); i >= 0 {
+		n, err := strconv.Atoi(rev[i+1:])
+		if err != nil {
+			return "", fmt.Errorf("Invalid revision %s", rev)
+		}
+		parents = n
+		rev = rev[:i]
+	}
+
+	hash := ""
+	if rev == "HEAD" {
+		hash = r.head
+	} else {
+		for h := range r.commits {
+			if strings.HasPrefix(h, rev) {
+				if hash != "" {
+					return "", fmt.Errorf("Ambiguous revision %s", rev)
+				}
+				hash = h
+			}
+		}
+	}
+	if hash == "" {
+		return "", fmt.Errorf("Unknown revision %s", rev)
+	}
+
+	for i := 0; i < parents; i++ {
+		hash = r.commits[hash].parent
+		if hash == "" {
+			return "", fmt.Errorf("Unknown revision %s", rev)
+		}
+	}
+	return hash, nil
+}
+
+func (r *MemoryRepo) fetch() {
+	r.remote.mu.Lock()
+	defer r.remote.mu.Unlock()
+	for hash, c := range r.remote.commits {
+		r.commits[hash] = c
+	}
+	r.upstream = r.remote.head
+}
+
+func (r *MemoryRepo) Fetch(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.remote != nil {
+		r.fetch()
+	}
+	return nil
+}
+
+func (r *MemoryRepo) Rebase(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if changed, err := r.hasChanges(); err != nil {
+		return err
+	} else if changed {
+		return fmt.Errorf("Failed to rebase git repo: working tree has uncommitted changes")
+	}
+
+	base := r.mergeBase(r.head, r.upstream)
+	if base == r.upstream {
+		return nil
+	}
+	if base == r.head {
+		// Fast forward
+		err := r.checkout(r.head, r.upstream)
+		if err != nil {
+			return err
+		}
+		r.head = r.upstream
+		return nil
+	}
+
+	// Replay local commits onto upstream, oldest first
+	local := r.ancestors(r.head)[:r.count(r.head, base)]
+	cur := r.upstream
+	for i := len(local) - 1; i >= 0; i-- {
+		c := r.commits[local[i]]
+		files, err := r.mergeFiles(r.files(c.parent), r.files(cur), c.files)
+		if err != nil {
+			return fmt.Errorf("Failed to rebase git repo: %w", err)
+		}
+		cur = r.commitAs(cur, c.Message, c.Author, c.Email, files)
+	}
+
+	err := r.checkout(r.head, cur)
+	if err != nil {
+		return err
+	}
+	r.head = cur
+	return nil
+}
+
+// mergeFiles applies the changes of theirs relative to base onto the
+// files of ours.
+func (r *MemoryRepo) mergeFiles(base, ours, theirs map[string][]byte) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	for name, content := range ours {
+		files[name] = content
+	}
+
+	names := map[string]bool{}
+	for name := range theirs {
+		names[name] = true
+	}
+	for name := range base {
+		names[name] = true
+	}
+
+	for name := range names {
+		theirContent, inTheirs := theirs[name]
+		baseContent, inBase := base[name]
+		ourContent, inOurs := ours[name]
+
+		switch {
+		case inTheirs == inBase && bytes.Equal(theirContent, baseContent):
+			// Not changed by the commit
+		case inOurs == inBase && bytes.Equal(ourContent, baseContent):
+			// Only changed by the commit
+			if inTheirs {
+				files[name] = theirContent
+			} else {
+				delete(files, name)
+			}
+		case inOurs == inTheirs && bytes.Equal(ourContent, theirContent):
+			// Same change on both sides
+		case inOurs && inTheirs && r.mergers[name] != nil:
+			merged, err := r.mergers[name](baseContent, ourContent, theirContent)
+			if err != nil {
+				return nil, err
+			}
+			files[name] = merged
+		default:
+			return nil, fmt.Errorf("%w in %s can not be merged automatically", ErrConflict, name)
+		}
+	}
+	return files, nil
+}
+
+func (r *MemoryRepo) FetchAndRebase(ctx context.Context) error {
+	err := r.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+	return r.Rebase(ctx)
+}
+
+func (r *MemoryRepo) hasChanges() (bool, error) {
+	files, err := r.snapshot()
+	if err != nil {
+		return false, err
+	}
+
+	committed := r.files(r.head)
+	if len(files) != len(committed) {
+		return true, nil
+	}
+	for name, content := range files {
+		if c, ok := committed[name]; !ok || !bytes.Equal(c, content) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *MemoryRepo) HasChanges(ctx context.Context) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hasChanges()
+}
+
+func (r *MemoryRepo) CommitAll(ctx context.Context, message string, author *Signature) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed, err := r.hasChanges()
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return fmt.Errorf("Failed to commit to git repo: nothing to commit")
+	}
+
+	files, err := r.snapshot()
+	if err != nil {
+		return err
+	}
+	if author != nil {
+		r.head = r.commitAs(r.head, message, author.Name, author.Email, files)
+	} else {
+		r.head = r.commit(r.head, message, files)
+	}
+	return nil
+}
+
+// Revert applies the inverse changes of rev to the working tree without
+// committing them.
+func (r *MemoryRepo) Revert(ctx context.Context, rev string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hash, err := r.resolve(rev)
+	if err != nil {
+		return err
+	}
+	// Reverting the initial commit removes its files, like git does
+	c := r.commits[hash]
+	// Reverts are applied on top of uncommitted ones
+	current, err := r.snapshot()
+	if err != nil {
+		return err
+	}
+	files, err := r.mergeFiles(c.files, current, r.files(c.parent))
+	if err != nil {
+		return fmt.Errorf("Failed to revert %s: %w", rev, err)
+	}
+	return r.writeTree(current, files)
+}
+
+func (r *MemoryRepo) Push(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.remote == nil {
+		r.upstream = r.head
+		return nil
+	}
+
+	r.remote.mu.Lock()
+	defer r.remote.mu.Unlock()
+	if r.mergeBase(r.head, r.remote.head) != r.remote.head {
+		return fmt.Errorf("Failed to push git repo: updates were rejected, fetch first")
+	}
+	for _, hash := range r.ancestors(r.head) {
+		r.remote.commits[hash] = r.commits[hash]
+	}
+	r.remote.head = r.head
+	r.upstream = r.head
+	return nil
+}
+
+func (r *MemoryRepo) Head(ctx context.Context) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.head, nil
+}
+
+func (r *MemoryRepo) ResetHard(ctx context.Context, rev string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hash, err := r.resolve(rev)
+	if err != nil {
+		return err
+	}
+
+	// Also drops uncommitted changes of files known to HEAD
+	err = r.checkout(r.head, hash)
+	if err != nil {
+		return err
+	}
+	r.head = hash
+	return nil
+}
+
+func (r *MemoryRepo) ResetSoft(ctx context.Context, rev string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hash, err := r.resolve(rev)
+	if err != nil {
+		return err
+	}
+	r.head = hash
+	return nil
+}
+
+func (r *MemoryRepo) MergeBase(ctx context.Context) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	base := r.mergeBase(r.head, r.upstream)
+	if base == "" {
+		return "", fmt.Errorf("Failed to find merge base of git repo")
+	}
+	return base, nil
+}
+
+func (r *MemoryRepo) AheadBehind(ctx context.Context) (int, int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	base := r.mergeBase(r.head, r.upstream)
+	return r.count(r.head, base), r.count(r.upstream, base), nil
+}
+
+func (r *MemoryRepo) Log(ctx context.Context, files ...string) ([]*Commit, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	commits := []*Commit{}
+	for _, hash := range r.ancestors(r.head) {
+		c := r.commits[hash]
+		parent := r.files(c.parent)
+		touched := len(files) == 0
+		for _, file := range files {
+			content, ok := c.files[file]
+			old, wasThere := parent[file]
+			if ok != wasThere || !bytes.Equal(content, old) {
+				touched = true
+				break
+			}
+		}
+		if touched {
+			commit := c.Commit
+			commits = append(commits, &commit)
+		}
+	}
+	return commits, nil
+}
+
+func (r *MemoryRepo) Show(ctx context.Context, rev string, file string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hash, err := r.resolve(rev)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to show %s of %s: %v: %w", file, rev, err, ErrNotFound)
+	}
+	content, ok := r.commits[hash].files[file]
+	if !ok {
+		return nil, fmt.Errorf("Failed to show %s of %s: %w", file, rev, ErrNotFound)
+	}
+	return content, nil
+}
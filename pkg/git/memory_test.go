@@ -0,0 +1,43 @@
+package git
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenMemoryRefusesURL(t *testing.T) {
+	_, err := Open(context.Background(), Config{
+		Backend: BackendMemory,
+		URL:     "https://example.com/todos.git",
+		Path:    t.TempDir(),
+	})
+	if err == nil {
+		t.Error("Open succeeded, want an error for the remote")
+	}
+}
+
+func TestMemoryRevertInitialCommit(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "todos.md")
+	if err := os.WriteFile(file, []byte("## 01/2023\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := NewMemoryRepo(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.Head(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Revert(ctx, head); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("reverting the initial commit kept %s", file)
+	}
+}
@@ -0,0 +1,134 @@
+package git
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Signature identifies the author of a commit.
+type Signature struct {
+	Name  string
+	Email string
+}
+
+func (s *Signature) String() string {
+	return fmt.Sprintf("%s <%s>", s.Name, s.Email)
+}
+
+var signatureRegex = regexp.MustCompile(`^(.*?)\s*<([^<>]*)>$`)
+
+// ParseSignature parses a signature written as "Name <email>", e.g. the
+// value of a Co-authored-by trailer. It returns nil if value is no
+// signature.
+func ParseSignature(value string) *Signature {
+	match := signatureRegex.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil || match[1] == "" {
+		return nil
+	}
+	return &Signature{match[1], match[2]}
+}
+
+type Trailer struct {
+	Key   string
+	Value string
+}
+
+// Message is a commit message with trailers, e.g. Todo-Action: complete,
+// that can be parsed back from the history.
+type Message struct {
+	Subject  string
+	Body     string
+	Trailers []Trailer
+}
+
+var trailerRegex = regexp.MustCompile(`^([A-Za-z0-9-]+): (.*)$`)
+
+func NewMessage(subject string) *Message {
+	return &Message{
+		Subject: subject,
+	}
+}
+
+// With adds a trailer, line breaks in value are replaced by spaces.
+func (m *Message) With(key, value string) *Message {
+	value = strings.Join(strings.Fields(value), " ")
+	m.Trailers = append(m.Trailers, Trailer{key, value})
+	return m
+}
+
+// Set replaces all trailers with key by a single one.
+func (m *Message) Set(key, value string) *Message {
+	trailers := m.Trailers[:0]
+	for _, t := range m.Trailers {
+		if !strings.EqualFold(t.Key, key) {
+			trailers = append(trailers, t)
+		}
+	}
+	m.Trailers = trailers
+	return m.With(key, value)
+}
+
+// Get returns the values of all trailers with key in order.
+func (m *Message) Get(key string) []string {
+	values := []string{}
+	for _, t := range m.Trailers {
+		if strings.EqualFold(t.Key, key) {
+			values = append(values, t.Value)
+		}
+	}
+	return values
+}
+
+func (m *Message) String() string {
+	var sb strings.Builder
+	sb.WriteString(m.Subject)
+	if m.Body != "" {
+		sb.WriteString("\n\n")
+		sb.WriteString(m.Body)
+	}
+	if len(m.Trailers) > 0 {
+		sb.WriteString("\n")
+		for i, t := range m.Trailers {
+			if i == 0 {
+				sb.WriteString("\n")
+			}
+			sb.WriteString(fmt.Sprintf("%s: %s\n", t.Key, t.Value))
+		}
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// ParseMessage splits a commit message into subject, body and trailers.
+// Trailers are read from the last paragraph if all its lines are
+// trailers.
+func ParseMessage(message string) *Message {
+	paragraphs := strings.Split(strings.TrimSpace(message), "\n\n")
+	m := &Message{
+		Subject: strings.TrimSpace(paragraphs[0]),
+	}
+	paragraphs = paragraphs[1:]
+
+	if len(paragraphs) > 0 {
+		last := strings.Split(strings.TrimSpace(paragraphs[len(paragraphs)-1]), "\n")
+		trailers := []Trailer{}
+		for _, line := range last {
+			match := trailerRegex.FindStringSubmatch(line)
+			if match == nil {
+				trailers = nil
+				break
+			}
+			trailers = append(trailers, Trailer{match[1], match[2]})
+		}
+		if trailers != nil {
+			m.Trailers = trailers
+			paragraphs = paragraphs[:len(paragraphs)-1]
+		}
+	}
+
+	m.Body = strings.TrimSpace(strings.Join(paragraphs, "\n\n"))
+	return m
+}
+
+// CoAuthoredBy is the trailer used to credit additional authors.
+const CoAuthoredBy = "Co-authored-by"
@@ -0,0 +1,17 @@
+package git
+
+import "testing"
+
+func TestParseSignature(t *testing.T) {
+	for value, want := range map[string]*Signature{
+		"Jane Doe <jane@example.com>": {Name: "Jane Doe", Email: "jane@example.com"},
+		" Jane <>":                    {Name: "Jane", Email: ""},
+		"jane@example.com":            nil,
+		"<jane@example.com>":          nil,
+	} {
+		got := ParseSignature(value)
+		if (got == nil) != (want == nil) || got != nil && *got != *want {
+			t.Errorf("ParseSignature(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
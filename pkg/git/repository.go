@@ -0,0 +1,207 @@
+package git
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// BackendCLI shells out to the git binary
+	BackendCLI = "cli"
+	// BackendMemory keeps the history in memory, no git binary needed. It
+	// has no remote and loses the history on restart.
+	BackendMemory = "memory"
+)
+
+// MergeFunc merges two conflicting versions of a file given their common
+// base. During a rebase, ours is the upstream version and theirs the
+// version of the local commit being replayed.
+type MergeFunc func(base, ours, theirs []byte) ([]byte, error)
+
+// ErrConflict is wrapped by errors of changes that can not be applied
+// because they conflict with other changes and no merger resolved them.
+var ErrConflict = errors.New("conflicting changes")
+
+// ErrNotFound is wrapped by errors of revisions or files that do not
+// exist.
+var ErrNotFound = errors.New("not found")
+
+type Commit struct {
+	Hash    string
+	Author  string
+	Email   string
+	Time    time.Time
+	Message string
+}
+
+// Repository is a cloned repository with a working tree on disk, tracking
+// a single upstream branch.
+type Repository interface {
+	// WorkDir returns the root of the working tree
+	WorkDir() string
+
+	Fetch(ctx context.Context) error
+	// Rebase rebases local commits onto the fetched upstream branch,
+	// resolving conflicts with the registered mergers. On failure the
+	// rebase is aborted and the working tree left clean.
+	Rebase(ctx context.Context) error
+	FetchAndRebase(ctx context.Context) error
+	RegisterMerger(file string, fn MergeFunc)
+
+	// CommitAll commits all changes of the working tree, using the
+	// configured identity if author is nil
+	CommitAll(ctx context.Context, message string, author *Signature) error
+	Push(ctx context.Context) error
+	HasChanges(ctx context.Context) (bool, error)
+	// Revert applies the inverse changes of rev to the working tree
+	// without committing them, conflicts are resolved with the registered
+	// mergers
+	Revert(ctx context.Context, rev string) error
+
+	Head(ctx context.Context) (string, error)
+	ResetHard(ctx context.Context, rev string) error
+	ResetSoft(ctx context.Context, rev string) error
+	// MergeBase returns the best common ancestor of HEAD and upstream
+	MergeBase(ctx context.Context) (string, error)
+	// AheadBehind returns the number of commits HEAD is ahead and behind
+	// of upstream, as of the last fetch
+	AheadBehind(ctx context.Context) (int, int, error)
+
+	// Log returns the commits reachable from HEAD touching any of files,
+	// or all commits without files, newest first
+	Log(ctx context.Context, files ...string) ([]*Commit, error)
+	// Show returns the content of file at rev, wrapping ErrNotFound if rev
+	// or file do not exist
+	Show(ctx context.Context, rev string, file string) ([]byte, error)
+}
+
+type Config struct {
+	// Backend is one of BackendCLI or BackendMemory
+	Backend string
+	// URL is cloned if Path does not exist yet
+	URL  string
+	Path string
+	// Timeout limits the runtime of every git command if not zero
+	Timeout time.Duration
+
+	// Remote defaults to origin
+	Remote string
+	// Branch to fetch from and push to, the upstream of the checked out
+	// branch if empty
+	Branch string
+
+	// AuthorName and AuthorEmail are used for commits, the git config
+	// applies if empty and DefaultAuthorName and DefaultAuthorEmail if
+	// git has none either
+	AuthorName  string
+	AuthorEmail string
+
+	// SSHKeyPath is the private key used for ssh remotes
+	SSHKeyPath string
+	// Token is sent as basic auth password to https remotes
+	Token string
+	// KnownHostsFile replaces ~/.ssh/known_hosts if not empty
+	KnownHostsFile string
+	// StrictHostKeyChecking is passed to ssh, e.g. yes or accept-new
+	StrictHostKeyChecking string
+}
+
+func (c Config) remote() string {
+	if c.Remote == "" {
+		return "origin"
+	}
+	return c.Remote
+}
+
+// env returns the environment passed to every git command. Credentials
+// are passed by environment instead of arguments to keep them out of
+// process listings and the repository config.
+func (c Config) env() []string {
+	env := []string{
+		// Fail instead of waiting for input, e.g. credentials or commit
+		// messages
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_EDITOR=true",
+		"GCM_INTERACTIVE=never",
+	}
+
+	if c.AuthorName != "" {
+		env = append(env, "GIT_AUTHOR_NAME="+c.AuthorName, "GIT_COMMITTER_NAME="+c.AuthorName)
+	}
+	if c.AuthorEmail != "" {
+		env = append(env, "GIT_AUTHOR_EMAIL="+c.AuthorEmail, "GIT_COMMITTER_EMAIL="+c.AuthorEmail)
+	}
+
+	ssh := []string{"ssh", "-o", "BatchMode=yes"}
+	if c.SSHKeyPath != "" {
+		ssh = append(ssh, "-i", c.SSHKeyPath, "-o", "IdentitiesOnly=yes")
+	}
+	if c.KnownHostsFile != "" {
+		ssh = append(ssh, "-o", "UserKnownHostsFile="+c.KnownHostsFile)
+	}
+	if c.StrictHostKeyChecking != "" {
+		ssh = append(ssh, "-o", "StrictHostKeyChecking="+c.StrictHostKeyChecking)
+	}
+	env = append(env, "GIT_SSH_COMMAND="+strings.Join(ssh, " "))
+
+	if c.Token != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + c.Token))
+		env = append(env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic "+auth,
+		)
+	}
+	return env
+}
+
+// TempFilePattern matches temporary files written next to tracked files,
+// e.g. by atomic writes. They are never committed.
+const TempFilePattern = ".*.tmp"
+
+// Open returns the repository configured by config, cloning it if the
+// path does not exist yet.
+func Open(ctx context.Context, config Config) (Repository, error) {
+	switch config.Backend {
+	case BackendCLI, "":
+		// Repo does not exist yet
+		if _, err := os.Stat(config.Path); err != nil {
+			err := os.MkdirAll(config.Path, 0775)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to create base repo dir: %s", err)
+			}
+			repo, err := Clone(ctx, config)
+			if err != nil {
+				return nil, err
+			}
+			repo.defaultIdentity(ctx, config)
+			return repo, repo.excludeTempFiles(ctx)
+		}
+
+		repo, err := Load(config.Path)
+		if err != nil {
+			return nil, err
+		}
+		repo.configure(config)
+		repo.defaultIdentity(ctx, config)
+		return repo, repo.excludeTempFiles(ctx)
+	case BackendMemory:
+		// The history would be lost on restart and never reach the remote
+		if config.URL != "" {
+			return nil, fmt.Errorf("Memory git backend can not clone %s, use the %s backend", config.URL, BackendCLI)
+		}
+		repo, err := NewMemoryRepo(config.Path, nil)
+		if err != nil {
+			return nil, err
+		}
+		repo.SetIdentity(config.AuthorName, config.AuthorEmail)
+		return repo, nil
+	}
+
+	return nil, fmt.Errorf("Unknown git backend %s", config.Backend)
+}
@@ -0,0 +1,140 @@
+package goals
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/martenwallewein/todo-service/pkg/markdown"
+	"github.com/martenwallewein/todo-service/pkg/timetracking"
+	"github.com/martenwallewein/todo-service/pkg/todos"
+	"github.com/martenwallewein/todo-service/pkg/workspace"
+)
+
+// GoalService manages the goals of months and of the year in the todo
+// list, their progress is read from linked tasks and tracked time.
+type GoalService struct {
+	ws                  *workspace.Workspace
+	todoService         *todos.TodoService
+	timeTrackingService *timetracking.TimeTrackingService
+	config              todos.Config
+}
+
+func NewGoalService(ws *workspace.Workspace, todoService *todos.TodoService, timeTrackingService *timetracking.TimeTrackingService, config todos.Config) *GoalService {
+	return &GoalService{
+		ws,
+		todoService,
+		timeTrackingService,
+		config,
+	}
+}
+
+// AddGoal adds task as goal of the current month, or of the year if yearly
+// is set.
+func (gs *GoalService) AddGoal(uow *workspace.UnitOfWork, task string, yearly bool, meta markdown.Metadata, notes string) (*markdown.TodoItem, error) {
+	tl, err := gs.todoService.Load(uow)
+	if err != nil {
+		return nil, err
+	}
+
+	goal := tl.AddGoal(time.Now(), task, yearly, gs.config.CarryOverGoals)
+	goal.Metadata.Update(meta)
+	if err := goal.SetNotes(notes); err != nil {
+		return nil, err
+	}
+	return goal, nil
+}
+
+// EditGoal replaces the text of the goal of ref if task is not empty and
+// updates its metadata. Goals are addressed as in
+// markdown.TodoList.GetGoal.
+func (gs *GoalService) EditGoal(uow *workspace.UnitOfWork, ref, task string, meta markdown.Metadata) (*markdown.TodoItem, error) {
+	goal, err := gs.getGoal(uow, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if task != "" {
+		goal.SetTask(task)
+	}
+	goal.Metadata.Update(meta)
+	return goal, nil
+}
+
+func (gs *GoalService) CompleteGoal(uow *workspace.UnitOfWork, ref string) (*markdown.TodoItem, error) {
+	goal, err := gs.getGoal(uow, ref)
+	if err != nil {
+		return nil, err
+	}
+	if err := goal.Complete(); err != nil {
+		return nil, err
+	}
+	return goal, nil
+}
+
+// RemoveGoal removes the goal of ref, tasks linking to it are kept.
+func (gs *GoalService) RemoveGoal(uow *workspace.UnitOfWork, ref string) (*markdown.TodoItem, error) {
+	tl, err := gs.todoService.Load(uow)
+	if err != nil {
+		return nil, err
+	}
+	goal, err := tl.GetGoal(ref)
+	if err != nil {
+		return nil, err
+	}
+	tl.RemoveGoal(goal)
+	return goal, nil
+}
+
+func (gs *GoalService) getGoal(uow *workspace.UnitOfWork, ref string) (*markdown.TodoItem, error) {
+	tl, err := gs.todoService.Load(uow)
+	if err != nil {
+		return nil, err
+	}
+	return tl.GetGoal(ref)
+}
+
+// GetProgress returns the progress of the goals of the month of date and
+// of its year.
+func (gs *GoalService) GetProgress(ctx context.Context, date time.Time) ([]*markdown.GoalProgress, error) {
+	var progress []*markdown.GoalProgress
+	err := gs.read(ctx, func(tl *markdown.TodoList, tracking *markdown.TimeTrackingList) {
+		progress = tl.GoalProgress(date, tracking)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return progress, nil
+}
+
+// GetSummary returns which goals moved in the month of date.
+func (gs *GoalService) GetSummary(ctx context.Context, date time.Time) (*markdown.GoalSummary, error) {
+	var summary *markdown.GoalSummary
+	err := gs.read(ctx, func(tl *markdown.TodoList, tracking *markdown.TimeTrackingList) {
+		summary = tl.GoalSummary(date, tracking)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// read runs fn on a consistent snapshot of the todo and time tracking
+// lists in the working tree. A missing time tracking list counts as no
+// time tracked.
+func (gs *GoalService) read(ctx context.Context, fn func(tl *markdown.TodoList, tracking *markdown.TimeTrackingList)) error {
+	return gs.ws.Read(func(repoPath string) error {
+		tl, err := gs.todoService.LoadTodoList(repoPath)
+		if err != nil {
+			return err
+		}
+		tracking, err := gs.timeTrackingService.LoadTimeTrackingList(repoPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		fn(tl, tracking)
+		return nil
+	})
+}
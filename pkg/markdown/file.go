@@ -0,0 +1,69 @@
+package markdown
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic replaces file with the content written by w. The content
+// goes to a temporary file in the same directory, which is synced and
+// renamed over file, so a crash leaves either the old or the new content.
+func writeFileAtomic(file string, w io.WriterTo) error {
+	dir := filepath.Dir(file)
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(file); err == nil {
+		perm = info.Mode().Perm()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	// Matches git.TempFilePattern, so a leftover is never committed
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("Failed to create temporary file for %s: %w", file, err)
+	}
+	// Leave no temporary file behind if anything fails
+	renamed := false
+	defer func() {
+		if !renamed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := w.WriteTo(tmp); err != nil {
+		return fmt.Errorf("Failed to write %s: %w", file, err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("Failed to sync %s: %w", file, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("Failed to write %s: %w", file, err)
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return fmt.Errorf("Failed to replace %s: %w", file, err)
+	}
+	renamed = true
+
+	return syncDir(dir)
+}
+
+// syncDir persists the rename of a file in dir.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("Failed to sync %s: %w", dir, err)
+	}
+	return nil
+}
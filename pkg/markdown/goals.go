@@ -0,0 +1,259 @@
+package markdown
+
+import (
+	"fmt"
+	"time"
+)
+
+// FindGoal returns the goal a task of month links to with key. Goals of
+// the month come before goals of the year, a goal matches if key is its
+// own goal key, its ID, or its text as in findTask. It returns
+// ErrTaskNotFound if no goal matches.
+func (tl *TodoList) FindGoal(month *TodoMonth, key string) (*TodoItem, error) {
+	goals := tl.Goals
+	if month != nil {
+		goals = append(append([]*TodoItem{}, month.Goals...), tl.Goals...)
+	}
+	for _, goal := range goals {
+		if goal.Goal == key {
+			return goal, nil
+		}
+	}
+	for _, goal := range goals {
+		if goal.ID == key {
+			return goal, nil
+		}
+	}
+	goal, err := findTask(goals, key)
+	if err != nil {
+		return nil, err
+	}
+	if goal == nil {
+		return nil, fmt.Errorf("%w: goal %s", ErrTaskNotFound, key)
+	}
+	return goal, nil
+}
+
+// GetGoal returns the goal of a month or the year with ref as ID, or a
+// goal of the current month or the year matching ref as text, see
+// findTask. It returns ErrTaskNotFound if there is none.
+func (tl *TodoList) GetGoal(ref string) (*TodoItem, error) {
+	goals := append([]*TodoItem{}, tl.Goals...)
+	for _, m := range tl.Months {
+		goals = append(goals, m.Goals...)
+	}
+	for _, goal := range goals {
+		if goal.ID == ref {
+			return goal, nil
+		}
+	}
+
+	goals = tl.Goals
+	if month := tl.GetCurrentMonth(); month != nil {
+		goals = append(append([]*TodoItem{}, month.Goals...), tl.Goals...)
+	}
+	goal, err := findTask(goals, ref)
+	if err != nil {
+		return nil, err
+	}
+	if goal == nil {
+		return nil, fmt.Errorf("%w: goal %s", ErrTaskNotFound, ref)
+	}
+	return goal, nil
+}
+
+// AddGoal adds a goal to the month of date, or to the goals of the year
+// if yearly is set, and returns it.
+func (tl *TodoList) AddGoal(date time.Time, task string, yearly bool, carryOverGoals bool) *TodoItem {
+	if tl.ids == nil {
+		tl.ids = idSet{}
+		tl.walk(func(item *TodoItem) {
+			tl.ids.add(item.ID)
+		})
+	}
+
+	task, meta := parseMetadata(task)
+	goal := &TodoItem{
+		ID:       tl.ids.next(),
+		Status:   StatusOpen,
+		Task:     task,
+		Metadata: meta,
+	}
+	if yearly {
+		tl.Goals = append(tl.Goals, goal)
+		return goal
+	}
+
+	month := tl.AddMonth(date, carryOverGoals)
+	goal.Day = month.Date
+	month.Goals = append(month.Goals, goal)
+	return goal
+}
+
+// RemoveGoal removes goal from its month or the goals of the year.
+func (tl *TodoList) RemoveGoal(goal *TodoItem) {
+	tl.Goals = removeItem(tl.Goals, goal)
+	for _, m := range tl.Months {
+		m.Goals = removeItem(m.Goals, goal)
+	}
+}
+
+func removeItem(items []*TodoItem, item *TodoItem) []*TodoItem {
+	for i, v := range items {
+		if v == item {
+			return append(items[:i:i], items[i+1:]...)
+		}
+	}
+	return items
+}
+
+// SetTask replaces the text of item, metadata written inline in task is
+// added to the metadata of the item.
+func (item *TodoItem) SetTask(task string) {
+	task, meta := parseMetadata(task)
+	item.Task = task
+	item.Metadata.Update(meta)
+}
+
+// GoalProgress sums up the tasks linked to a goal and the time tracked for
+// them.
+type GoalProgress struct {
+	Goal   *TodoItem
+	Yearly bool
+	// Tasks linked to the goal, subtasks count with their parent and tasks
+	// moved by a rollover only once
+	Tasks     int
+	Done      int
+	Cancelled int
+	// Estimate is the sum of the estimates of the linked tasks
+	Estimate Duration
+	Tracked  Duration
+	// Percent of the tasks not cancelled that are done, 100 once the goal
+	// is done
+	Percent int
+}
+
+// GoalProgress returns the progress of the goals of the month of date, and
+// of the goals of the year over all months of the year. Time is read from
+// tracking, which may be nil.
+func (tl *TodoList) GoalProgress(date time.Time, tracking *TimeTrackingList) []*GoalProgress {
+	month := tl.GetMonth(date)
+	progress := []*GoalProgress{}
+	if month != nil {
+		for _, goal := range month.Goals {
+			progress = append(progress, tl.progress(goal, false, []*TodoMonth{month}, tracking))
+		}
+	}
+
+	year := []*TodoMonth{}
+	for _, m := range tl.Months {
+		if m.Date.Year() == date.Year() {
+			year = append(year, m)
+		}
+	}
+	for _, goal := range tl.Goals {
+		progress = append(progress, tl.progress(goal, true, year, tracking))
+	}
+	return progress
+}
+
+// GoalSummary tells which goals moved within a month.
+type GoalSummary struct {
+	Month time.Time
+	// Moved are the goals completed in the month or with tasks done or
+	// time tracked in it
+	Moved []*GoalProgress
+	// Stalled are the open goals without any of that
+	Stalled []*GoalProgress
+}
+
+// GoalSummary returns the summary of the goals of the month of date and
+// of the year, counting only tasks and time of the month.
+func (tl *TodoList) GoalSummary(date time.Time, tracking *TimeTrackingList) *GoalSummary {
+	summary := &GoalSummary{
+		Month:   time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.Local),
+		Moved:   []*GoalProgress{},
+		Stalled: []*GoalProgress{},
+	}
+	month := tl.GetMonth(date)
+	if month == nil {
+		return summary
+	}
+
+	progress := []*GoalProgress{}
+	for _, goal := range month.Goals {
+		progress = append(progress, tl.progress(goal, false, []*TodoMonth{month}, tracking))
+	}
+	for _, goal := range tl.Goals {
+		progress = append(progress, tl.progress(goal, true, []*TodoMonth{month}, tracking))
+	}
+	for _, p := range progress {
+		switch {
+		// A goal of the year may have been completed in another month
+		case p.Done > 0 || p.Tracked > 0 || !p.Yearly && p.Goal.Status == StatusDone:
+			summary.Moved = append(summary.Moved, p)
+		case !p.Goal.Status.Closed():
+			summary.Stalled = append(summary.Stalled, p)
+		}
+	}
+	return summary
+}
+
+// progress sums up the tasks of months linked to goal.
+func (tl *TodoList) progress(goal *TodoItem, yearly bool, months []*TodoMonth, tracking *TimeTrackingList) *GoalProgress {
+	p := &GoalProgress{
+		Goal:   goal,
+		Yearly: yearly,
+	}
+	ids := map[string]bool{}
+	for _, m := range months {
+		for _, item := range m.Items {
+			if item.Goal == "" {
+				continue
+			}
+			if linked, err := tl.FindGoal(m, item.Goal); err != nil || linked != goal {
+				continue
+			}
+			walkItems([]*TodoItem{item}, func(item *TodoItem) {
+				if item.ID != "" {
+					ids[item.ID] = true
+				}
+			})
+			// Tasks moved by a rollover count with their copy
+			if item.attrs[attrMoved] != "" {
+				continue
+			}
+
+			p.Tasks++
+			switch item.Status {
+			case StatusDone:
+				p.Done++
+			case StatusCancelled:
+				p.Cancelled++
+			}
+			p.Estimate += item.Estimate
+		}
+	}
+
+	if tracking != nil {
+		for _, m := range months {
+			tm := tracking.GetMonth(m.Date)
+			if tm == nil {
+				continue
+			}
+			for _, item := range tm.Items {
+				if item.TodoID != "" && ids[item.TodoID] {
+					p.Tracked += Duration(item.duration())
+				}
+			}
+		}
+	}
+
+	switch {
+	case goal.Status == StatusDone:
+		p.Percent = 100
+	case p.Tasks > p.Cancelled:
+		p.Percent = p.Done * 100 / (p.Tasks - p.Cancelled)
+	}
+	return p
+}
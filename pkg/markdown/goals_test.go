@@ -0,0 +1,80 @@
+package markdown
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetGoalByText(t *testing.T) {
+	now := time.Now()
+	tl, err := ParseMarkdownReader(strings.NewReader(fmt.Sprintf(`- goals:
+    - [ ] Run a marathon
+## %02d/%d
+- goals:
+    - [ ] Ship release
+    - [ ] Ship docs
+- todos:
+`, now.Month(), now.Year())))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range []string{"ship release", "Run a marathon"} {
+		if goal, err := tl.GetGoal(ref); err != nil || !strings.EqualFold(goal.Task, ref) {
+			t.Errorf("GetGoal(%q) returned %v, %v", ref, goal, err)
+		}
+	}
+	var ambiguousErr *AmbiguousTaskError
+	if _, err := tl.GetGoal("Ship"); !errors.As(err, &ambiguousErr) || len(ambiguousErr.Matches) != 2 {
+		t.Errorf("GetGoal(Ship) returned %v, want both goals as candidates", err)
+	}
+	if _, err := tl.GetGoal("Learn Go"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("GetGoal(Learn Go) returned %v, want ErrTaskNotFound", err)
+	}
+}
+
+func TestCarriedOverGoalsHaveIDs(t *testing.T) {
+	tl, err := ParseMarkdownReader(strings.NewReader("## 01/2023\n- goals:\n    - [ ] Ship release\n    - [x] Done goal\n- todos:\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	month := tl.AddMonth(time.Date(2023, time.February, 1, 0, 0, 0, 0, time.Local), true)
+	if len(month.Goals) != 1 || month.Goals[0].ID == "" {
+		t.Fatalf("carried over goals %v, want Ship release with ID", month.Goals)
+	}
+	if goal, err := tl.GetGoal(month.Goals[0].ID); err != nil || goal != month.Goals[0] {
+		t.Errorf("GetGoal returned %v, %v", goal, err)
+	}
+}
+
+func TestProgressCountsRolledOverTasksOnce(t *testing.T) {
+	tl, err := ParseMarkdownReader(strings.NewReader(`## 10/2025
+- goals:
+    - [ ] Ship release <!-- id:gggggg -->
+- todos:
+    - 01.10:
+        - [ ] 1) Write changelog goal:gggggg ~2h
+        - [x] 2) Tag release goal:gggggg ~1h
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for day := 2; day <= 3; day++ {
+		date := time.Date(2025, time.October, day, 0, 0, 0, 0, time.Local)
+		if moved := tl.Rollover(date, RolloverPolicy{}, false); len(moved) != 1 {
+			t.Fatalf("rollover to %s moved %v", date, moved)
+		}
+	}
+
+	progress := tl.GoalProgress(time.Date(2025, time.October, 3, 0, 0, 0, 0, time.Local), nil)
+	if len(progress) != 1 {
+		t.Fatalf("progress of %d goals, want 1", len(progress))
+	}
+	p := progress[0]
+	if p.Tasks != 2 || p.Done != 1 || p.Estimate != Duration(3*time.Hour) || p.Percent != 50 {
+		t.Errorf("progress %d tasks, %d done, estimate %s, %d%%, want 2, 1, 3h, 50%%", p.Tasks, p.Done, p.Estimate, p.Percent)
+	}
+}
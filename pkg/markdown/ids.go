@@ -0,0 +1,154 @@
+package markdown
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Items are identified by a short random ID, stored in a trailing comment
+// of their line that is hidden when the markdown is rendered, e.g.
+// "[ ] 1) Write paper <!-- id:k3x9a1 -->".
+const (
+	attrID   = "id"
+	attrTodo = "todo"
+
+	idLength   = 6
+	idAlphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+)
+
+var ErrTaskNotFound = errors.New("Task not found")
+
+// AmbiguousTaskError is returned if a task is addressed by a text or an ID
+// that matches more than one task, or by a text only contained in tasks.
+type AmbiguousTaskError struct {
+	Query   string
+	Matches []*TodoItem
+	// Partial is set if the matches only contain Query
+	Partial bool
+}
+
+func (e *AmbiguousTaskError) Error() string {
+	tasks := make([]string, 0, len(e.Matches))
+	for _, item := range e.Matches {
+		tasks = append(tasks, fmt.Sprintf("%q", item.Task))
+	}
+	if e.Partial {
+		return fmt.Sprintf("No task is %q, tasks containing it: %s", e.Query, strings.Join(tasks, ", "))
+	}
+	return fmt.Sprintf("%q matches %d tasks: %s", e.Query, len(e.Matches), strings.Join(tasks, ", "))
+}
+
+// idSet tracks the IDs used in a list, so that new IDs are unique.
+type idSet map[string]bool
+
+func (ids idSet) add(id string) {
+	if id != "" {
+		ids[id] = true
+	}
+}
+
+func (ids idSet) next() string {
+	for {
+		id := randomID()
+		if !ids[id] {
+			ids[id] = true
+			return id
+		}
+	}
+}
+
+func randomID() string {
+	b := make([]byte, idLength)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("Failed to read random bytes: %v", err))
+	}
+	for i := range b {
+		b[i] = idAlphabet[int(b[i])%len(idAlphabet)]
+	}
+	return string(b)
+}
+
+// attrsComment renders attrs as trailing comment, empty if there are none.
+// Known keys come first to keep lines stable.
+func attrsComment(attrs map[string]string) string {
+	keys := make([]string, 0, len(attrs))
+	for key, value := range attrs {
+		if value != "" && key != attrID && key != attrTodo {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range []string{attrTodo, attrID} {
+		if attrs[key] != "" {
+			keys = append([]string{key}, keys...)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+
+	fields := make([]string, 0, len(keys))
+	for _, key := range keys {
+		fields = append(fields, key+":"+attrs[key])
+	}
+	return fmt.Sprintf("<!-- %s -->", strings.Join(fields, " "))
+}
+
+// otherAttrs returns the attrs not modeled by an item.
+func otherAttrs(attrs map[string]string, known ...string) map[string]string {
+	other := map[string]string{}
+	for key, value := range attrs {
+		other[key] = value
+	}
+	for _, key := range known {
+		delete(other, key)
+	}
+	return other
+}
+
+var taskNumberRegex = regexp.MustCompile(`^\d+\)\s*`)
+
+// matchTasks returns the items whose task equals query, ignoring case,
+// numbering and metadata. candidates are the items containing query,
+// offered if no task equals it.
+func matchTasks(items []*TodoItem, query string) (matches, candidates []*TodoItem) {
+	if task, _ := parseMetadata(query); task != "" {
+		query = task
+	}
+	if _, task := splitNumber(strings.TrimSpace(query)); task != "" {
+		query = task
+	}
+	query = strings.ToLower(strings.TrimSpace(query))
+	matches = []*TodoItem{}
+	candidates = []*TodoItem{}
+	for _, item := range items {
+		task := strings.ToLower(item.Task)
+		switch {
+		case task == query || taskNumberRegex.ReplaceAllString(task, "") == query:
+			matches = append(matches, item)
+		case strings.Contains(task, query):
+			candidates = append(candidates, item)
+		}
+	}
+	return matches, candidates
+}
+
+// findTask returns the item of items matching query, see matchTasks, nil
+// if there is none. It returns *AmbiguousTaskError if several items match,
+// or if items only contain query.
+func findTask(items []*TodoItem, query string) (*TodoItem, error) {
+	matches, candidates := matchTasks(items, query)
+	switch {
+	case len(matches) == 1:
+		return matches[0], nil
+	case len(matches) > 1:
+		return nil, &AmbiguousTaskError{Query: query, Matches: matches}
+	case len(candidates) > 0:
+		return nil, &AmbiguousTaskError{Query: query, Matches: candidates, Partial: true}
+	}
+	return nil, nil
+}
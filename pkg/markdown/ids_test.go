@@ -0,0 +1,98 @@
+package markdown
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindTask(t *testing.T) {
+	items := []*TodoItem{
+		{Task: "Hercules meeting invitation"},
+		{Task: "Write report"},
+		{Task: "Write report draft"},
+	}
+	tests := []struct {
+		query   string
+		want    *TodoItem
+		partial []*TodoItem
+	}{
+		{"hercules MEETING invitation", items[0], nil},
+		{"2) Hercules meeting invitation #work", items[0], nil},
+		{"write report", items[1], nil},
+		{"Meeting", nil, items[:1]},
+		{"Write", nil, items[1:]},
+		{"Lunch", nil, nil},
+	}
+	for _, test := range tests {
+		item, err := findTask(items, test.query)
+		var ambiguousErr *AmbiguousTaskError
+		switch {
+		case test.partial != nil:
+			if !errors.As(err, &ambiguousErr) || !ambiguousErr.Partial || len(ambiguousErr.Matches) != len(test.partial) {
+				t.Errorf("findTask(%q) returned %v, %v, want candidates %v", test.query, item, err, test.partial)
+			}
+		case err != nil || item != test.want:
+			t.Errorf("findTask(%q) returned %v, %v, want %v", test.query, item, err, test.want)
+		}
+	}
+}
+
+func TestWriteAssignsIDsToChangedItemsOnly(t *testing.T) {
+	content := `# Todos
+
+- goals:
+    - [ ] Year goal
+## 10/2025
+- goals:
+    - [ ] Month goal
+- todos:
+    - 01.10:
+        - [ ] 1) past task <!-- id:aaaaaa -->
+            - [ ] subtask
+        - [ ] 2)  spaced   task #tag
+        - [X] 3) done
+`
+	tl, err := ParseMarkdownReader(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := tl.GetTask("aaaaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subtask := parent.Children[0]
+	if err := subtask.Complete(); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "todos.md")
+	if err := tl.WriteToFile(file); err != nil {
+		t.Fatal(err)
+	}
+	written, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subtask.ID == "" {
+		t.Fatal("changed subtask has no ID")
+	}
+	want := strings.Replace(content, "- [ ] subtask", "- [x] subtask <!-- id:"+subtask.ID+" -->", 1)
+	if string(written) != want {
+		t.Errorf("written\n%s\nwant\n%s", written, want)
+	}
+}
+
+func TestTimeTrackingAddMonthKeepsIDsUnique(t *testing.T) {
+	tl := &TimeTrackingList{Months: []*TimeTrackingMonth{{
+		Date:  time.Date(2023, time.January, 1, 0, 0, 0, 0, time.Local),
+		Items: []*TimeTrackingItem{{ID: "aaaaaa", Task: "a"}},
+	}}}
+	month := tl.AddMonth(time.Date(2023, time.February, 1, 0, 0, 0, 0, time.Local))
+	if !month.ids["aaaaaa"] {
+		t.Errorf("IDs of the new month %v miss aaaaaa of the list", month.ids)
+	}
+}
@@ -0,0 +1,444 @@
+package markdown
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MergeConflict describes a single item that was changed differently on
+// both sides of a merge.
+type MergeConflict struct {
+	Month  string `json:"month"`
+	Task   string `json:"task"`
+	Reason string `json:"reason"`
+}
+
+func (c MergeConflict) String() string {
+	return fmt.Sprintf("%s: %q %s", c.Month, c.Task, c.Reason)
+}
+
+// MergeError is returned if a merge contains true conflicts that can not
+// be resolved automatically.
+type MergeError struct {
+	File      string
+	Conflicts []MergeConflict
+}
+
+func (e *MergeError) Error() string {
+	lines := make([]string, 0, len(e.Conflicts))
+	for _, c := range e.Conflicts {
+		lines = append(lines, c.String())
+	}
+	return fmt.Sprintf("Failed to merge %s, %d conflicts: %s", e.File, len(e.Conflicts), strings.Join(lines, "; "))
+}
+
+// MergeTodoLists merges the changes of ours and theirs relative to base on
+// the level of single todo items. Status changes, additions, removals and
+// reorders done on one side are applied, if both sides changed the same
+// item differently the item is reported as conflict. The merged list is
+// written like ours, content of theirs is kept for months and items taken
+// from theirs.
+func MergeTodoLists(base, ours, theirs *TodoList) (*TodoList, []MergeConflict) {
+	conflicts := []MergeConflict{}
+	merged := &TodoList{
+		Numbered: ours.Numbered,
+		src:      ours.src,
+		goalsSrc: ours.goalsSrc,
+		ids:      idSet{},
+	}
+
+	goals, c := todoMerger(taskKey).merge("year", base.Goals, ours.Goals, theirs.Goals)
+	merged.Goals = goals
+	conflicts = append(conflicts, c...)
+
+	baseMonths := monthsByKey(base)
+	ourMonths := monthsByKey(ours)
+	theirMonths := monthsByKey(theirs)
+
+	keys := []string{}
+	dates := map[string]*TodoMonth{}
+	for _, tl := range []*TodoList{ours, theirs} {
+		for _, m := range tl.Months {
+			if m == nil {
+				continue
+			}
+			key := monthKey(m)
+			if _, ok := dates[key]; !ok {
+				keys = append(keys, key)
+				dates[key] = m
+			}
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return dates[keys[i]].Date.Before(dates[keys[j]].Date)
+	})
+
+	for _, key := range keys {
+		baseMonth := baseMonths[key]
+		ourMonth, inOurs := ourMonths[key]
+		theirMonth, inTheirs := theirMonths[key]
+
+		// Month removed on one side
+		if baseMonth != nil && (!inOurs || !inTheirs) {
+			conflicts = append(conflicts, MergeConflict{key, "", "month removed on one side"})
+			continue
+		}
+
+		if baseMonth == nil {
+			baseMonth = &TodoMonth{}
+		}
+		if ourMonth == nil {
+			ourMonth = &TodoMonth{}
+		}
+		if theirMonth == nil {
+			theirMonth = &TodoMonth{}
+		}
+
+		// The month keeps the sources of the side it is taken from
+		src := dates[key]
+		if inOurs {
+			src = ourMonth
+		}
+		month := &TodoMonth{
+			Date:     src.Date,
+			src:      src.src,
+			srcDate:  src.srcDate,
+			goalsSrc: src.goalsSrc,
+			todosSrc: src.todosSrc,
+			days:     src.days,
+			ids:      merged.ids,
+		}
+
+		month.Goals, c = todoMerger(taskKey).merge(key, baseMonth.Goals, ourMonth.Goals, theirMonth.Goals)
+		conflicts = append(conflicts, c...)
+
+		month.Items, c = todoMerger(dayTaskKey).merge(key, baseMonth.Items, ourMonth.Items, theirMonth.Items)
+		conflicts = append(conflicts, c...)
+
+		// Keep days in order, new items of one side may have been
+		// inserted behind items of another day
+		sort.SliceStable(month.Items, func(i, j int) bool {
+			return dateOf(month.Items[i].Day).Before(dateOf(month.Items[j].Day))
+		})
+
+		merged.Months = append(merged.Months, month)
+	}
+
+	merged.walk(func(item *TodoItem) {
+		merged.ids.add(item.ID)
+	})
+	return merged, conflicts
+}
+
+// MergeTimeTrackingLists merges the changes of ours and theirs relative
+// to base on the level of single time trackings, like MergeTodoLists.
+// Trackings are identified by their start and task, a tracking stopped on
+// one side is taken from that side.
+func MergeTimeTrackingLists(base, ours, theirs *TimeTrackingList) (*TimeTrackingList, []MergeConflict) {
+	conflicts := []MergeConflict{}
+	merged := &TimeTrackingList{
+		src: ours.src,
+		ids: idSet{},
+	}
+
+	baseMonths := trackingMonthsByKey(base)
+	ourMonths := trackingMonthsByKey(ours)
+	theirMonths := trackingMonthsByKey(theirs)
+
+	keys := []string{}
+	dates := map[string]*TimeTrackingMonth{}
+	for _, tl := range []*TimeTrackingList{ours, theirs} {
+		for _, m := range tl.Months {
+			key := dateKey(m.Date)
+			if _, ok := dates[key]; !ok {
+				keys = append(keys, key)
+				dates[key] = m
+			}
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return dates[keys[i]].Date.Before(dates[keys[j]].Date)
+	})
+
+	merger := itemMerger[*TimeTrackingItem]{
+		key:  trackingKey,
+		same: sameTracking,
+		task: func(item *TimeTrackingItem) string { return item.Task },
+		withID: func(item, other *TimeTrackingItem) *TimeTrackingItem {
+			if item.ID == "" {
+				item.ID = other.ID
+			}
+			return item
+		},
+	}
+	for _, key := range keys {
+		baseMonth := baseMonths[key]
+		ourMonth, inOurs := ourMonths[key]
+		theirMonth, inTheirs := theirMonths[key]
+
+		if baseMonth != nil && (!inOurs || !inTheirs) {
+			conflicts = append(conflicts, MergeConflict{key, "", "month removed on one side"})
+			continue
+		}
+
+		if baseMonth == nil {
+			baseMonth = &TimeTrackingMonth{}
+		}
+		if ourMonth == nil {
+			ourMonth = &TimeTrackingMonth{}
+		}
+		if theirMonth == nil {
+			theirMonth = &TimeTrackingMonth{}
+		}
+
+		// The month keeps the sources of the side it is taken from
+		src := dates[key]
+		if inOurs {
+			src = ourMonth
+		}
+		month := &TimeTrackingMonth{
+			Date:     src.Date,
+			src:      src.src,
+			srcDate:  src.srcDate,
+			timesSrc: src.timesSrc,
+			days:     src.days,
+			ids:      merged.ids,
+		}
+
+		var c []MergeConflict
+		month.Items, c = merger.merge(key, baseMonth.Items, ourMonth.Items, theirMonth.Items)
+		conflicts = append(conflicts, c...)
+		sort.SliceStable(month.Items, func(i, j int) bool {
+			return dateOf(month.Items[i].Start).Before(dateOf(month.Items[j].Start))
+		})
+
+		for _, item := range month.Items {
+			merged.ids.add(item.ID)
+		}
+		merged.Months = append(merged.Months, month)
+	}
+	return merged, conflicts
+}
+
+func trackingMonthsByKey(tl *TimeTrackingList) map[string]*TimeTrackingMonth {
+	months := map[string]*TimeTrackingMonth{}
+	for _, m := range tl.Months {
+		months[dateKey(m.Date)] = m
+	}
+	return months
+}
+
+func trackingKey(item *TimeTrackingItem) string {
+	return fmt.Sprintf("%s|%s", item.Start.Format("2006-01-02T15:04"), item.Task)
+}
+
+// sameTracking compares the end of a and b and the todo they track.
+func sameTracking(a, b *TimeTrackingItem) bool {
+	return a.InProgress == b.InProgress && a.End.Equal(b.End) && a.TodoID == b.TodoID
+}
+
+func monthKey(m *TodoMonth) string {
+	return dateKey(m.Date)
+}
+
+// dateKey identifies the month of date.
+func dateKey(date time.Time) string {
+	return fmt.Sprintf("%s/%d", appendZeroIfMissing(int(date.Month())), date.Year())
+}
+
+func monthsByKey(tl *TodoList) map[string]*TodoMonth {
+	months := map[string]*TodoMonth{}
+	for _, m := range tl.Months {
+		if m != nil {
+			months[monthKey(m)] = m
+		}
+	}
+	return months
+}
+
+func taskKey(item *TodoItem) string {
+	return item.Task
+}
+
+func dayTaskKey(item *TodoItem) string {
+	return fmt.Sprintf("%s|%s", item.Day.Format("2006-01-02"), item.Task)
+}
+
+// keyed assigns a unique key to every item, duplicates are numbered by
+// their occurrence.
+func keyed[T any](items []T, keyFn func(T) string) ([]string, map[string]T) {
+	keys := make([]string, 0, len(items))
+	byKey := map[string]T{}
+	seen := map[string]int{}
+	for _, item := range items {
+		key := keyFn(item)
+		seen[key]++
+		if seen[key] > 1 {
+			key = fmt.Sprintf("%s#%d", key, seen[key])
+		}
+		keys = append(keys, key)
+		byKey[key] = item
+	}
+	return keys, byKey
+}
+
+// sameStatus compares the status, metadata and notes of a and b and their
+// subtasks, changed subtasks count as change of the item.
+func sameStatus(a, b *TodoItem) bool {
+	if a.Status != b.Status || len(a.Children) != len(b.Children) {
+		return false
+	}
+	if a.Metadata.inline() != b.Metadata.inline() || a.Notes != b.Notes {
+		return false
+	}
+	for i := range a.Children {
+		if a.Children[i].Task != b.Children[i].Task || !sameStatus(a.Children[i], b.Children[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// itemMerger merges the versions of a list of items of type T.
+type itemMerger[T any] struct {
+	// key identifies an item across versions
+	key func(T) string
+	// same compares the state of two versions of an item
+	same func(a, b T) bool
+	// task names an item in conflicts
+	task func(T) string
+	// withID returns item, with the ID of other if it has none
+	withID func(item, other T) T
+}
+
+func todoMerger(keyFn func(*TodoItem) string) itemMerger[*TodoItem] {
+	return itemMerger[*TodoItem]{
+		key:    keyFn,
+		same:   sameStatus,
+		task:   func(item *TodoItem) string { return item.Task },
+		withID: withID,
+	}
+}
+
+func (m itemMerger[T]) merge(month string, base, ours, theirs []T) ([]T, []MergeConflict) {
+	conflicts := []MergeConflict{}
+	baseKeys, baseItems := keyed(base, m.key)
+	ourKeys, ourItems := keyed(ours, m.key)
+	theirKeys, theirItems := keyed(theirs, m.key)
+
+	common := map[string]bool{}
+	for _, key := range baseKeys {
+		_, inOurs := ourItems[key]
+		_, inTheirs := theirItems[key]
+		common[key] = inOurs && inTheirs
+	}
+
+	// The side that reordered the common items defines the order
+	order, other := ourKeys, theirKeys
+	if sameOrder(baseKeys, ourKeys, common) && !sameOrder(baseKeys, theirKeys, common) {
+		order, other = theirKeys, ourKeys
+	}
+	keys := insertMissing(order, other)
+
+	result := []T{}
+	for _, key := range keys {
+		b, inBase := baseItems[key]
+		o, inOurs := ourItems[key]
+		t, inTheirs := theirItems[key]
+
+		switch {
+		case inOurs && inTheirs:
+			switch {
+			case m.same(o, t):
+				result = append(result, m.withID(o, t))
+			case inBase && m.same(b, o):
+				result = append(result, m.withID(t, o))
+			case inBase && m.same(b, t):
+				result = append(result, m.withID(o, t))
+			default:
+				conflicts = append(conflicts, MergeConflict{month, m.task(o), "status changed on both sides"})
+			}
+		case !inBase:
+			// Added on one side
+			if inOurs {
+				result = append(result, o)
+			} else {
+				result = append(result, t)
+			}
+		case inOurs && !m.same(b, o):
+			conflicts = append(conflicts, MergeConflict{month, m.task(o), "changed on one side and removed on the other"})
+		case inTheirs && !m.same(b, t):
+			conflicts = append(conflicts, MergeConflict{month, m.task(t), "changed on one side and removed on the other"})
+		}
+		// Otherwise removed on at least one side without changes on the other
+	}
+
+	return result, conflicts
+}
+
+// withID returns item, with the ID assigned on the other side if it has
+// none.
+func withID(item, other *TodoItem) *TodoItem {
+	if item.ID == "" {
+		item.ID = other.ID
+	}
+	return item
+}
+
+// sameOrder checks if the keys contained in common appear in the same
+// order in a and b.
+func sameOrder(a, b []string, common map[string]bool) bool {
+	filter := func(list []string) []string {
+		filtered := []string{}
+		for _, key := range list {
+			if common[key] {
+				filtered = append(filtered, key)
+			}
+		}
+		return filtered
+	}
+
+	fa := filter(a)
+	fb := filter(b)
+	if len(fa) != len(fb) {
+		return false
+	}
+	for i := range fa {
+		if fa[i] != fb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// insertMissing inserts all keys of other missing in order behind their
+// predecessor in other.
+func insertMissing(order, other []string) []string {
+	result := append([]string{}, order...)
+	index := map[string]int{}
+	for i, key := range result {
+		index[key] = i
+	}
+
+	for i, key := range other {
+		if _, ok := index[key]; ok {
+			continue
+		}
+
+		pos := 0
+		for j := i - 1; j >= 0; j-- {
+			if p, ok := index[other[j]]; ok {
+				pos = p + 1
+				break
+			}
+		}
+
+		result = InsertIntoSliceAtIndex(result, key, pos)
+		for k, v := range result {
+			index[v] = k
+		}
+	}
+	return result
+}
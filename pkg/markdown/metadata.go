@@ -0,0 +1,291 @@
+package markdown
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type Priority string
+
+const (
+	PriorityHigh   Priority = "high"
+	PriorityMedium Priority = "medium"
+	PriorityLow    Priority = "low"
+)
+
+func (p Priority) Valid() bool {
+	return p == "" || p == PriorityHigh || p == PriorityMedium || p == PriorityLow
+}
+
+// Duration is a time.Duration read and written as string like 1h30m.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	str := time.Duration(d).String()
+	// 2h0m0s reads better as 2h
+	if strings.HasSuffix(str, "m0s") {
+		str = str[:len(str)-2]
+	}
+	if strings.HasSuffix(str, "h0m") {
+		str = str[:len(str)-2]
+	}
+	return str
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	if d == 0 {
+		return json.Marshal("")
+	}
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	if str == "" {
+		*d = 0
+		return nil
+	}
+	parsed, err := time.ParseDuration(str)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+const dateLayout = "2006-01-02"
+
+// Date is a day read and written as string like 2023-01-20.
+type Date struct {
+	time.Time
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	if d.IsZero() {
+		return json.Marshal("")
+	}
+	return json.Marshal(d.Format(dateLayout))
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	if str == "" {
+		*d = Date{}
+		return nil
+	}
+	parsed, err := time.ParseInLocation(dateLayout, str, time.Local)
+	if err != nil {
+		return fmt.Errorf("Invalid date %s, expected YYYY-MM-DD", str)
+	}
+	*d = Date{parsed}
+	return nil
+}
+
+// Metadata of a task, written inline after its text in the order
+// "#research !high due:2023-01-20 ~2h goal:hercules".
+type Metadata struct {
+	Tags     []string
+	Priority Priority
+	// Due is zero if the task has no due date
+	Due      Date
+	Estimate Duration
+	// Goal links a task to a goal, see TodoList.FindGoal. On a goal it is
+	// the key tasks link to.
+	Goal string
+}
+
+var (
+	tagRegex     = regexp.MustCompile(`^#[A-Za-z][\w/-]*$`)
+	goalKeyRegex = regexp.MustCompile(`^[\w-]+$`)
+)
+
+// parseMetadata splits the trailing metadata words off text. Words in the
+// middle of the text are kept as they are, e.g. "Fix #12 for #work".
+func parseMetadata(text string) (string, Metadata) {
+	meta := Metadata{}
+	words := strings.Fields(text)
+	end := len(words)
+	for end > 0 && meta.parseWord(words[end-1]) {
+		end--
+	}
+	if end == len(words) {
+		return text, meta
+	}
+
+	// Tags were read from the end
+	for i, j := 0, len(meta.Tags)-1; i < j; i, j = i+1, j-1 {
+		meta.Tags[i], meta.Tags[j] = meta.Tags[j], meta.Tags[i]
+	}
+	return strings.Join(words[:end], " "), meta
+}
+
+// parseWord reads a single metadata word into meta, it reports false if
+// word is not metadata or its kind was read already.
+func (meta *Metadata) parseWord(word string) bool {
+	switch {
+	case tagRegex.MatchString(word):
+		meta.Tags = append(meta.Tags, word[1:])
+	case strings.HasPrefix(word, "!"):
+		priority := Priority(word[1:])
+		if priority == "" || !priority.Valid() || meta.Priority != "" {
+			return false
+		}
+		meta.Priority = priority
+	case strings.HasPrefix(word, "due:"):
+		due, err := time.ParseInLocation(dateLayout, word[4:], time.Local)
+		if err != nil || !meta.Due.IsZero() {
+			return false
+		}
+		meta.Due = Date{due}
+	case strings.HasPrefix(word, "goal:"):
+		if !goalKeyRegex.MatchString(word[5:]) || meta.Goal != "" {
+			return false
+		}
+		meta.Goal = word[5:]
+	case strings.HasPrefix(word, "~"):
+		estimate, err := time.ParseDuration(word[1:])
+		if err != nil || estimate <= 0 || meta.Estimate != 0 {
+			return false
+		}
+		meta.Estimate = Duration(estimate)
+	default:
+		return false
+	}
+	return true
+}
+
+// inline renders meta in canonical order, empty if there is none.
+func (meta Metadata) inline() string {
+	words := []string{}
+	for _, tag := range meta.Tags {
+		words = append(words, "#"+tag)
+	}
+	if meta.Priority != "" {
+		words = append(words, "!"+string(meta.Priority))
+	}
+	if !meta.Due.IsZero() {
+		words = append(words, "due:"+meta.Due.Format(dateLayout))
+	}
+	if meta.Estimate != 0 {
+		words = append(words, "~"+meta.Estimate.String())
+	}
+	if meta.Goal != "" {
+		words = append(words, "goal:"+meta.Goal)
+	}
+	return strings.Join(words, " ")
+}
+
+// Validate checks metadata given by API clients.
+func (meta Metadata) Validate() error {
+	for _, tag := range meta.Tags {
+		if !tagRegex.MatchString("#" + tag) {
+			return fmt.Errorf("Invalid tag %q", tag)
+		}
+	}
+	if !meta.Priority.Valid() {
+		return fmt.Errorf("Invalid priority %q, expected high, medium or low", meta.Priority)
+	}
+	if meta.Estimate < 0 {
+		return fmt.Errorf("Invalid estimate %s", meta.Estimate)
+	}
+	if meta.Goal != "" && !goalKeyRegex.MatchString(meta.Goal) {
+		return fmt.Errorf("Invalid goal %q, expected letters, digits, _ or -", meta.Goal)
+	}
+	return nil
+}
+
+// Update overrides the fields set in other and adds its tags.
+func (meta *Metadata) Update(other Metadata) {
+	for _, tag := range other.Tags {
+		if !meta.HasTag(tag) {
+			meta.Tags = append(meta.Tags, tag)
+		}
+	}
+	if other.Priority != "" {
+		meta.Priority = other.Priority
+	}
+	if !other.Due.IsZero() {
+		meta.Due = other.Due
+	}
+	if other.Estimate != 0 {
+		meta.Estimate = other.Estimate
+	}
+	if other.Goal != "" {
+		meta.Goal = other.Goal
+	}
+}
+
+func (meta Metadata) HasTag(tag string) bool {
+	for _, t := range meta.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// withMetadata returns task followed by meta.
+func withMetadata(task string, meta Metadata) string {
+	if str := meta.inline(); str != "" {
+		return task + " " + str
+	}
+	return task
+}
+
+// TaskFilter selects tasks by their metadata, zero values match
+// everything.
+type TaskFilter struct {
+	Tag      string
+	Priority Priority
+	// DueBefore matches tasks due on or before the day
+	DueBefore time.Time
+}
+
+func (f TaskFilter) Empty() bool {
+	return f.Tag == "" && f.Priority == "" && f.DueBefore.IsZero()
+}
+
+func (f TaskFilter) Matches(item *TodoItem) bool {
+	if f.Tag != "" && !item.HasTag(f.Tag) {
+		return false
+	}
+	if f.Priority != "" && item.Priority != f.Priority {
+		return false
+	}
+	if !f.DueBefore.IsZero() && (item.Due.IsZero() || dateOf(item.Due.Time).After(dateOf(f.DueBefore))) {
+		return false
+	}
+	return true
+}
+
+// FilterTasks returns all tasks of the list matching f, goals and subtasks
+// included.
+func (tl *TodoList) FilterTasks(f TaskFilter) []*TodoItem {
+	items := []*TodoItem{}
+	tl.walk(func(item *TodoItem) {
+		if f.Matches(item) {
+			items = append(items, item)
+		}
+	})
+	return items
+}
+
+// FilterTasks returns the items matching f.
+func FilterTasks(items []*TodoItem, f TaskFilter) []*TodoItem {
+	filtered := []*TodoItem{}
+	for _, item := range items {
+		if f.Matches(item) {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,83 @@
+package markdown
+
+import (
+	"fmt"
+	"strings"
+)
+
+// noteIndent is the indentation of notes below their item, if they are
+// written for the first time.
+const noteIndent = "    "
+
+// ValidateNotes checks notes given by API clients, lines that would be
+// read back as item, day or month are refused.
+func ValidateNotes(notes string) error {
+	for _, l := range strings.Split(notes, "\n") {
+		if structural(l) {
+			return fmt.Errorf("Invalid note line %q, notes cannot contain items, days or months", strings.TrimSpace(l))
+		}
+	}
+	return nil
+}
+
+// SetNotes replaces the notes of item, see ValidateNotes. Trailing
+// whitespace and blank lines, which would end the notes in the file, are
+// removed.
+func (item *TodoItem) SetNotes(notes string) error {
+	if err := ValidateNotes(notes); err != nil {
+		return err
+	}
+	lines := []string{}
+	for _, l := range strings.Split(notes, "\n") {
+		l = strings.TrimRight(l, " \t\r")
+		if strings.TrimSpace(l) != "" {
+			lines = append(lines, l)
+		}
+	}
+	if len(lines) > 0 {
+		lines = dedent(lines)
+	}
+	item.Notes = strings.Join(lines, "\n")
+	return nil
+}
+
+// structural reports whether line is read as part of the structure
+// instead of a note.
+func structural(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	if heading := strings.TrimPrefix(trimmed, "## "); heading != trimmed {
+		return monthHeadingRegex.MatchString(strings.TrimSpace(heading))
+	}
+	if text := strings.TrimPrefix(trimmed, "- "); text != trimmed {
+		text = strings.TrimSpace(text)
+		return strings.HasPrefix(text, "[") || dayRegex.MatchString(text)
+	}
+	return false
+}
+
+// noteLines returns the lines of the notes of item indented below its line
+// at indent. Unchanged notes keep their original lines.
+func (item *TodoItem) noteLines(indent string) []string {
+	if item.src != nil && item.Notes == item.srcNotes {
+		return item.src.Notes
+	}
+	if item.Notes == "" {
+		return nil
+	}
+
+	// Keep the indentation of notes that were edited
+	indent += noteIndent
+	if item.src != nil {
+		for i, l := range item.src.Notes {
+			prefix := l[:len(l)-len(strings.TrimLeft(l, " \t"))]
+			if i == 0 || len(prefix) < len(indent) {
+				indent = prefix
+			}
+		}
+	}
+	lines := []string{}
+	for _, l := range strings.Split(item.Notes, "\n") {
+		lines = append(lines, indent+l)
+	}
+	return lines
+}
@@ -0,0 +1,517 @@
+package markdown
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pos is a position in a file, lines and columns start at 1.
+type Pos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// ParseError is a single problem found at a position of a file.
+type ParseError struct {
+	Pos
+	Message string `json:"message"`
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+// ParseErrors lists every problem found in a file.
+type ParseErrors struct {
+	File   string
+	Errors []*ParseError
+}
+
+func (e *ParseErrors) Error() string {
+	lines := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		lines = append(lines, err.Error())
+	}
+	file := e.File
+	if file == "" {
+		file = "document"
+	}
+	return fmt.Sprintf("Failed to parse %s, %d problems: %s", file, len(e.Errors), strings.Join(lines, "; "))
+}
+
+// parseErrors returns errs sorted by position as error, nil if there are
+// none.
+func parseErrors(errs []*ParseError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].Line != errs[j].Line {
+			return errs[i].Line < errs[j].Line
+		}
+		return errs[i].Column < errs[j].Column
+	})
+	return &ParseErrors{Errors: errs}
+}
+
+func errorf(pos Pos, format string, args ...interface{}) *ParseError {
+	return &ParseError{pos, fmt.Sprintf(format, args...)}
+}
+
+type tokenKind int
+
+const (
+	blankToken tokenKind = iota
+	headingToken
+	listToken
+	textToken
+)
+
+// token is a single line of a file.
+type token struct {
+	kind tokenKind
+	// pos of the first non blank character
+	pos    Pos
+	raw    string
+	indent int
+	// level of a heading
+	level int
+	// text following the heading or list marker
+	text    string
+	textPos Pos
+}
+
+// tokenize splits the content of r into lines, it reports whether the
+// last line is terminated by a newline.
+func tokenize(r io.Reader) ([]*token, bool, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(content) == 0 {
+		return nil, true, nil
+	}
+
+	lines := strings.Split(string(content), "\n")
+	finalNewline := lines[len(lines)-1] == ""
+	if finalNewline {
+		lines = lines[:len(lines)-1]
+	}
+
+	tokens := make([]*token, 0, len(lines))
+	for i, raw := range lines {
+		line := i + 1
+		trimmed := strings.TrimLeft(raw, " \t")
+		t := &token{
+			raw:    raw,
+			indent: len(raw) - len(trimmed),
+		}
+		t.pos = Pos{line, t.indent + 1}
+		trimmed = strings.TrimRight(trimmed, " \t\r")
+
+		switch {
+		case trimmed == "":
+			t.kind = blankToken
+		case strings.HasPrefix(trimmed, "#"):
+			t.kind = headingToken
+			t.level = len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+			rest := trimmed[t.level:]
+			t.text = strings.TrimLeft(rest, " \t")
+			t.textPos = Pos{line, t.pos.Column + t.level + len(rest) - len(t.text)}
+		case trimmed == "-" || strings.HasPrefix(trimmed, "- "):
+			t.kind = listToken
+			rest := strings.TrimPrefix(trimmed, "-")
+			t.text = strings.TrimLeft(rest, " \t")
+			t.textPos = Pos{line, t.pos.Column + 1 + len(rest) - len(t.text)}
+		default:
+			t.kind = textToken
+			t.text = trimmed
+			t.textPos = t.pos
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, finalNewline, nil
+}
+
+// Source holds the original lines of a node, so that unchanged content is
+// written back byte for byte.
+type Source struct {
+	// Leading blank lines before the node
+	Leading []string
+	Raw     string
+	// Notes are the lines indented below an item, see ItemNode.Notes
+	Notes []string
+	// Trailing lines that are not part of the structure, e.g. other
+	// headings
+	Trailing []string
+}
+
+// sourceTracker assigns every line that is not part of the structure to
+// the node before it.
+type sourceTracker struct {
+	doc  *Document
+	last *Source
+	// Blank lines not followed by other content yet
+	blanks []string
+}
+
+func (st *sourceTracker) node(t *token) *Source {
+	src := &Source{
+		Leading: st.blanks,
+		Raw:     t.raw,
+	}
+	st.blanks = nil
+	st.last = src
+	return src
+}
+
+func (st *sourceTracker) other(t *token) {
+	switch {
+	case st.last == nil:
+		st.doc.Preamble = append(st.doc.Preamble, t.raw)
+	case t.kind == blankToken:
+		st.blanks = append(st.blanks, t.raw)
+	default:
+		st.last.Trailing = append(st.last.Trailing, st.blanks...)
+		st.last.Trailing = append(st.last.Trailing, t.raw)
+		st.blanks = nil
+	}
+}
+
+func (st *sourceTracker) end() {
+	st.doc.Epilogue = st.blanks
+}
+
+// Document is the syntax tree shared by todo and time tracking files:
+// months contain sections like goals or todos, which contain items
+// directly or grouped by day.
+type Document struct {
+	// Sections before the first month, e.g. the goals of the year
+	Sections []*SectionNode
+	Months   []*MonthNode
+
+	// Preamble are the lines before the first node, Epilogue the blank
+	// lines after the last one
+	Preamble     []string
+	Epilogue     []string
+	FinalNewline bool
+}
+
+type MonthNode struct {
+	Src      *Source
+	Pos      Pos
+	Month    time.Month
+	Year     int
+	Sections []*SectionNode
+}
+
+type SectionNode struct {
+	Src  *Source
+	Pos  Pos
+	Name string
+	// Items not grouped by day, e.g. goals
+	Items []*ItemNode
+	Days  []*DayNode
+}
+
+type DayNode struct {
+	Src *Source
+	Pos Pos
+	Day int
+	// Month and year of the day, the year is taken from the enclosing
+	// month heading
+	Month time.Month
+	Year  int
+	Items []*ItemNode
+}
+
+// ItemNode is a list item starting with a bracketed marker, the checkbox
+// of a todo or the time range of a time tracking.
+type ItemNode struct {
+	Src *Source
+	Pos Pos
+	// Items indented below the item
+	Children []*ItemNode
+	// Marker is the content between the brackets
+	Marker    string
+	MarkerPos Pos
+	Text      string
+	TextPos   Pos
+	// Attrs are the key:value pairs of a trailing HTML comment, hidden
+	// when the markdown is rendered, e.g. the ID of the item
+	Attrs map[string]string
+	// Notes are the lines of text right below the item, indented deeper
+	// than it, without their common indentation
+	Notes []string
+}
+
+var (
+	monthHeadingRegex = regexp.MustCompile(`^(\S+)/(\S+)$`)
+	sectionRegex      = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9 _-]*):$`)
+	dayRegex          = regexp.MustCompile(`^(\d{1,2})\.(\d{1,2})\.?:?$`)
+	dayLikeRegex      = regexp.MustCompile(`^\S*\d\S*:$`)
+)
+
+// ParseDocument parses the structure of a todo or time tracking file.
+// Content it does not know, like other headings or free text, is skipped.
+// All problems found are returned as *ParseErrors.
+func ParseDocument(r io.Reader) (*Document, error) {
+	tokens, finalNewline, err := tokenize(r)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, errs := parseDocument(tokens, finalNewline)
+	if err := parseErrors(errs); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func parseDocument(tokens []*token, finalNewline bool) (*Document, []*ParseError) {
+	doc := &Document{
+		FinalNewline: finalNewline,
+	}
+	errs := []*ParseError{}
+	st := &sourceTracker{doc: doc}
+
+	var month *MonthNode
+	var section *SectionNode
+	var day *DayNode
+	// Items that may get children, innermost last
+	var parents []*ItemNode
+	// Item the lines before belong to, until a line is not a note
+	var noteOwner *ItemNode
+
+	for _, t := range tokens {
+		owner := noteOwner
+		noteOwner = nil
+
+		switch {
+		case t.kind == headingToken && t.level == 2 && monthHeadingRegex.MatchString(t.text):
+			match := monthHeadingRegex.FindStringSubmatch(t.text)
+			m, err := parseMonth(t, match[1], match[2])
+			if err != nil {
+				errs = append(errs, err)
+				// Keep items of the broken month out of the previous one
+				m = &MonthNode{Pos: t.pos}
+			}
+			m.Src = st.node(t)
+			month = m
+			section = nil
+			day = nil
+			parents = nil
+			doc.Months = append(doc.Months, month)
+
+		case t.kind == listToken && strings.HasPrefix(t.text, "["):
+			item, err := parseItem(t)
+			if err != nil {
+				errs = append(errs, err)
+				st.other(t)
+				continue
+			}
+
+			// Items indented deeper than the one before are its children
+			for len(parents) > 0 && parents[len(parents)-1].Pos.Column >= item.Pos.Column {
+				parents = parents[:len(parents)-1]
+			}
+			// Empty checkboxes are kept as they are, like other text
+			if item.Text == "" {
+				st.other(t)
+				continue
+			}
+			item.Src = st.node(t)
+			noteOwner = item
+			parents = append(parents, item)
+			if len(parents) > 1 {
+				parent := parents[len(parents)-2]
+				parent.Children = append(parent.Children, item)
+				continue
+			}
+
+			switch {
+			case day != nil:
+				day.Items = append(day.Items, item)
+			case section != nil:
+				section.Items = append(section.Items, item)
+			default:
+				errs = append(errs, errorf(t.pos, "item %q outside of a section", t.text))
+			}
+
+		case t.kind == listToken && t.indent == 0 && sectionRegex.MatchString(t.text):
+			section = &SectionNode{
+				Src:  st.node(t),
+				Pos:  t.pos,
+				Name: sectionRegex.FindStringSubmatch(t.text)[1],
+			}
+			day = nil
+			parents = nil
+			if month != nil {
+				month.Sections = append(month.Sections, section)
+			} else {
+				doc.Sections = append(doc.Sections, section)
+			}
+
+		case t.kind == listToken && dayRegex.MatchString(t.text):
+			match := dayRegex.FindStringSubmatch(t.text)
+			d, err := parseDay(t, match[1], match[2], month)
+			if err != nil {
+				errs = append(errs, err)
+				// Keep items of the broken day out of the previous one
+				d = &DayNode{Pos: t.pos}
+			}
+			d.Src = st.node(t)
+			if section == nil {
+				errs = append(errs, errorf(t.pos, "day %q outside of a section", t.text))
+				continue
+			}
+			day = d
+			section.Days = append(section.Days, day)
+			parents = nil
+
+		case owner != nil && t.kind != blankToken && t.indent >= owner.Pos.Column:
+			owner.Src.Notes = append(owner.Src.Notes, t.raw)
+			owner.Notes = dedent(owner.Src.Notes)
+			noteOwner = owner
+
+		default:
+			if t.kind == listToken && dayLikeRegex.MatchString(t.text) {
+				errs = append(errs, errorf(t.textPos, "invalid day %q, expected DD.MM:", t.text))
+			}
+			// Other headings, list items and text are not part of the
+			// structure but kept with the node before them
+			st.other(t)
+		}
+	}
+	st.end()
+
+	return doc, errs
+}
+
+// dedent removes the indentation common to all lines and trailing
+// whitespace.
+func dedent(lines []string) []string {
+	indent := -1
+	for _, l := range lines {
+		n := len(l) - len(strings.TrimLeft(l, " \t"))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	dedented := make([]string, 0, len(lines))
+	for _, l := range lines {
+		dedented = append(dedented, strings.TrimRight(l[indent:], " \t\r"))
+	}
+	return dedented
+}
+
+func parseMonth(t *token, monthStr, yearStr string) (*MonthNode, *ParseError) {
+	pos := t.textPos
+	month, err := strconv.Atoi(monthStr)
+	if err != nil || month < 1 || month > 12 {
+		return nil, errorf(pos, "invalid month %q, expected MM/YYYY", monthStr+"/"+yearStr)
+	}
+	year, err := strconv.Atoi(yearStr)
+	if err != nil || year < 1 || year > 9999 {
+		return nil, errorf(pos, "invalid year %q, expected MM/YYYY", monthStr+"/"+yearStr)
+	}
+	return &MonthNode{
+		Pos:   t.pos,
+		Month: time.Month(month),
+		Year:  year,
+	}, nil
+}
+
+func parseDay(t *token, dayStr, monthStr string, month *MonthNode) (*DayNode, *ParseError) {
+	pos := t.textPos
+	m, _ := strconv.Atoi(monthStr)
+	if m < 1 || m > 12 {
+		return nil, errorf(pos, "invalid month %s in day %s.%s", monthStr, dayStr, monthStr)
+	}
+
+	year := dayYear(month, time.Month(m))
+	d, _ := strconv.Atoi(dayStr)
+	if d < 1 || d > daysIn(time.Month(m), year) {
+		return nil, errorf(pos, "invalid day %s in day %s.%s", dayStr, dayStr, monthStr)
+	}
+	return &DayNode{
+		Pos:   t.pos,
+		Day:   d,
+		Month: time.Month(m),
+		Year:  year,
+	}, nil
+}
+
+// dayYear returns the year of a day in month, days of the neighbouring
+// month across the turn of the year belong to the year before or after.
+func dayYear(month *MonthNode, m time.Month) int {
+	if month == nil || month.Year == 0 {
+		// Leap years only matter within a month
+		return 2000
+	}
+	switch {
+	case month.Month == time.December && m == time.January:
+		return month.Year + 1
+	case month.Month == time.January && m == time.December:
+		return month.Year - 1
+	}
+	return month.Year
+}
+
+func daysIn(month time.Month, year int) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func parseItem(t *token) (*ItemNode, *ParseError) {
+	end := strings.Index(t.text, "]")
+	if end < 0 {
+		return nil, errorf(t.textPos, "missing ] in item %q", t.text)
+	}
+
+	text := t.text[end+1:]
+	if text != "" && !strings.HasPrefix(text, " ") {
+		return nil, errorf(Pos{t.textPos.Line, t.textPos.Column + end + 1}, "missing space after ] in item %q", t.text)
+	}
+	textPos := Pos{t.textPos.Line, t.textPos.Column + end + 1 + len(text) - len(strings.TrimLeft(text, " "))}
+
+	// Only the last comment holds attributes, others are part of the text
+	var attrs map[string]string
+	trimmedText := strings.TrimRight(text, " \t\r")
+	if start := strings.LastIndex(trimmedText, "<!--"); start >= 0 && strings.HasSuffix(trimmedText, "-->") && start+4 <= len(trimmedText)-3 {
+		if parsed, ok := parseAttrs(trimmedText[start+4 : len(trimmedText)-3]); ok {
+			attrs = parsed
+			text = trimmedText[:start]
+		}
+	}
+
+	return &ItemNode{
+		Pos:       t.pos,
+		Marker:    t.text[1:end],
+		MarkerPos: Pos{t.textPos.Line, t.textPos.Column + 1},
+		Text:      strings.TrimSpace(text),
+		TextPos:   textPos,
+		Attrs:     attrs,
+	}, nil
+}
+
+// parseAttrs parses space separated key:value pairs.
+func parseAttrs(content string) (map[string]string, bool) {
+	attrs := map[string]string{}
+	for _, field := range strings.Fields(content) {
+		key, value, ok := strings.Cut(field, ":")
+		if !ok || key == "" || value == "" {
+			return nil, false
+		}
+		attrs[key] = value
+	}
+	return attrs, len(attrs) > 0
+}
@@ -0,0 +1,50 @@
+package markdown
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEmptyCheckboxesAreKept(t *testing.T) {
+	content := `## 01/2023
+- goals:
+- todos:
+    - 07.01:
+        - [ ] 
+        - [ ] 1) task
+        - [x] #tag
+`
+	tl, err := ParseMarkdownReader(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(tl.Months[0].Items); n != 2 {
+		t.Errorf("parsed %d items, want 2", n)
+	}
+	if got := tl.String(); got != content {
+		t.Errorf("written\n%q\nwant\n%q", got, content)
+	}
+
+	tracking, err := ParseTimeTrackingMarkdownReader(strings.NewReader("## 01/2023\n- times:\n    - 07.01:\n        - [10:00-11:00] \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(tracking.Months[0].Items); n != 0 {
+		t.Errorf("parsed %d time trackings, want 0", n)
+	}
+}
+
+func TestRepositoryTodosRoundTrip(t *testing.T) {
+	content, err := os.ReadFile("../../todos.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl, err := ParseMarkdownReader(strings.NewReader(string(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tl.String(); got != string(content) {
+		t.Errorf("todos.md changed when written back")
+	}
+}
@@ -0,0 +1,121 @@
+package markdown
+
+import "time"
+
+// attrMoved links a task moved by a rollover to its copy.
+const attrMoved = "moved"
+
+// RolloverPolicy selects the unfinished tasks of past days that are moved
+// to today.
+type RolloverPolicy struct {
+	// SkipWeekends moves no tasks on Saturdays and Sundays, weekend days
+	// do not count towards MaxAge
+	SkipWeekends bool
+	// MaxAge in days of the tasks moved, older tasks are left behind. 0
+	// moves tasks of any age.
+	MaxAge int
+	// Tags moves only tasks with one of the tags, all tasks if empty
+	Tags []string
+}
+
+// Matches reports whether item of a past day is moved to date.
+func (p RolloverPolicy) Matches(item *TodoItem, date time.Time) bool {
+	if item.Status != StatusOpen && item.Status != StatusInProgress {
+		return false
+	}
+	if !dateOf(item.Day).Before(dateOf(date)) {
+		return false
+	}
+	if p.MaxAge > 0 && p.age(item.Day, date) > p.MaxAge {
+		return false
+	}
+	if len(p.Tags) == 0 {
+		return true
+	}
+	for _, tag := range p.Tags {
+		if item.HasTag(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// age returns the number of days from day to date.
+func (p RolloverPolicy) age(day, date time.Time) int {
+	age := 0
+	for d := dateOf(day).AddDate(0, 0, 1); !d.After(dateOf(date)); d = d.AddDate(0, 0, 1) {
+		if !p.SkipWeekends || !weekend(d) {
+			age++
+		}
+	}
+	return age
+}
+
+func weekend(date time.Time) bool {
+	return date.Weekday() == time.Saturday || date.Weekday() == time.Sunday
+}
+
+// Rollover moves the tasks of past days selected by policy to date and
+// returns their copies in order. Subtasks not closed move with their
+// task. The originals are kept as deferred and link to their copy, so
+// that a task is moved only once.
+func (tl *TodoList) Rollover(date time.Time, policy RolloverPolicy, carryOverGoals bool) []*TodoItem {
+	moved := []*TodoItem{}
+	if policy.SkipWeekends && weekend(date) {
+		return moved
+	}
+	for _, m := range tl.Months {
+		for _, item := range m.Items {
+			if policy.Matches(item, date) {
+				moved = append(moved, item)
+			}
+		}
+	}
+	if len(moved) == 0 {
+		return moved
+	}
+
+	if tl.ids == nil {
+		tl.ids = idSet{}
+		tl.walk(func(item *TodoItem) {
+			tl.ids.add(item.ID)
+		})
+	}
+	month := tl.AddMonth(date, carryOverGoals)
+	copies := make([]*TodoItem, 0, len(moved))
+	for _, item := range moved {
+		c := tl.moveItem(item, date, nil)
+		month.insertTask(c)
+		copies = append(copies, c)
+	}
+	return copies
+}
+
+// moveItem returns a copy of item on date and defers item.
+func (tl *TodoList) moveItem(item *TodoItem, date time.Time, parent *TodoItem) *TodoItem {
+	c := &TodoItem{
+		ID:       tl.ids.next(),
+		Status:   item.Status,
+		Task:     item.Task,
+		Day:      date,
+		Metadata: item.Metadata,
+		Notes:    item.Notes,
+		parent:   parent,
+	}
+	for _, child := range item.Children {
+		if !child.Status.Closed() {
+			c.Children = append(c.Children, tl.moveItem(child, date, c))
+		}
+	}
+
+	if item.ID == "" {
+		item.ID = tl.ids.next()
+	}
+	if item.attrs == nil {
+		item.attrs = map[string]string{}
+	}
+	item.attrs[attrMoved] = c.ID
+	// Open, in progress and blocked tasks can all be deferred
+	item.Status = StatusDeferred
+	return c
+}
@@ -0,0 +1,244 @@
+package markdown
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// documentSource is the content of a parsed file outside of its nodes.
+type documentSource struct {
+	preamble       []string
+	epilogue       []string
+	noFinalNewline bool
+}
+
+func newDocumentSource(doc *Document) *documentSource {
+	return &documentSource{
+		preamble:       doc.Preamble,
+		epilogue:       doc.Epilogue,
+		noFinalNewline: !doc.FinalNewline,
+	}
+}
+
+// lineWriter streams the lines of a rendered file to an io.Writer. The
+// first error stops all further writes and is returned by end.
+type lineWriter struct {
+	w     *bufio.Writer
+	out   *countingWriter
+	lines int
+	err   error
+}
+
+func newLineWriter(w io.Writer) *lineWriter {
+	out := &countingWriter{w: w}
+	return &lineWriter{
+		w:   bufio.NewWriter(out),
+		out: out,
+	}
+}
+
+// countingWriter counts the bytes that reached w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (w *lineWriter) write(line string) {
+	if w.err != nil {
+		return
+	}
+	// Lines are separated, the final newline is written by end
+	if w.lines > 0 {
+		w.writeString("\n")
+	}
+	w.writeString(line)
+	w.lines++
+}
+
+func (w *lineWriter) writeString(s string) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.WriteString(s)
+}
+
+func (w *lineWriter) line(format string, args ...interface{}) {
+	w.write(fmt.Sprintf(format, args...))
+}
+
+// node writes line together with the lines kept around it in src, if the
+// node was parsed.
+func (w *lineWriter) node(src *Source, line string) {
+	var notes []string
+	if src != nil {
+		notes = src.Notes
+	}
+	w.nodeNotes(src, line, notes)
+}
+
+// nodeNotes is node with the notes of src replaced by notes.
+func (w *lineWriter) nodeNotes(src *Source, line string, notes []string) {
+	if src == nil {
+		w.write(line)
+		for _, l := range notes {
+			w.write(l)
+		}
+		return
+	}
+	for _, l := range src.Leading {
+		w.write(l)
+	}
+	w.write(line)
+	for _, l := range notes {
+		w.write(l)
+	}
+	for _, l := range src.Trailing {
+		w.write(l)
+	}
+}
+
+func (w *lineWriter) begin(doc *documentSource) {
+	if doc == nil {
+		return
+	}
+	for _, l := range doc.preamble {
+		w.write(l)
+	}
+}
+
+// end finishes the file and returns the number of bytes written.
+func (w *lineWriter) end(doc *documentSource) (int64, error) {
+	if doc != nil {
+		for _, l := range doc.epilogue {
+			w.write(l)
+		}
+	}
+	if w.lines > 0 && (doc == nil || !doc.noFinalNewline) {
+		w.writeString("\n")
+	}
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	return w.out.n, w.err
+}
+
+// itemSource is the original line of a parsed item.
+type itemSource struct {
+	*Source
+	// Indent of the list marker
+	indent string
+	text   string
+	attrs  string
+	// Trailing whitespace of Raw, e.g. \r
+	eol string
+	// Indexes of the brackets around the marker in Raw
+	markerStart int
+	markerEnd   int
+}
+
+func newItemSource(node *ItemNode) *itemSource {
+	start := node.MarkerPos.Column - 2
+	return &itemSource{
+		Source:      node.Src,
+		indent:      node.Src.Raw[:node.Pos.Column-1],
+		text:        node.Text,
+		attrs:       attrsComment(node.Attrs),
+		eol:         node.Src.Raw[len(strings.TrimRight(node.Src.Raw, " \t\r")):],
+		markerStart: start,
+		markerEnd:   start + 1 + len(node.Marker),
+	}
+}
+
+// itemLine renders a new item.
+func itemLine(indent, marker, text, attrs string) string {
+	line := fmt.Sprintf("%s- [%s] %s", indent, marker, text)
+	if attrs != "" {
+		line += " " + attrs
+	}
+	return line
+}
+
+// line renders an item, touching only the parts of the original line that
+// changed.
+func (src *itemSource) line(marker string, markerChanged bool, text, attrs string) string {
+	switch {
+	case text != src.text || attrs != src.attrs:
+		line := fmt.Sprintf("%s[%s] %s", src.Raw[:src.markerStart], marker, text)
+		if attrs != "" {
+			line += " " + attrs
+		}
+		return line + src.eol
+	case markerChanged:
+		return src.Raw[:src.markerStart+1] + marker + src.Raw[src.markerEnd:]
+	default:
+		return src.Raw
+	}
+}
+
+// dayBlock is a day heading of a section. Items are rendered below the
+// block they were parsed in, new items below the last block of their day.
+type dayBlock struct {
+	date time.Time
+	src  *Source
+	// Number of items parsed in the day
+	items int
+}
+
+func newDayBlock(date time.Time, src *Source) *dayBlock {
+	return &dayBlock{
+		date: dateOf(date),
+		src:  src,
+	}
+}
+
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// groupByDay assigns items to the day blocks they are rendered below,
+// blocks for new days are added in order of their date.
+func groupByDay[T any](blocks []*dayBlock, items []T, day func(T) time.Time, block func(T) *dayBlock) ([]*dayBlock, map[*dayBlock][]T) {
+	result := append([]*dayBlock{}, blocks...)
+	known := map[*dayBlock]bool{}
+	for _, b := range blocks {
+		known[b] = true
+	}
+
+	grouped := map[*dayBlock][]T{}
+	for _, item := range items {
+		date := dateOf(day(item))
+		b := block(item)
+		if b == nil || !known[b] || !b.date.Equal(date) {
+			b = nil
+			for i := len(result) - 1; i >= 0; i-- {
+				if result[i].date.Equal(date) {
+					b = result[i]
+					break
+				}
+			}
+		}
+		if b == nil {
+			b = newDayBlock(date, nil)
+			pos := len(result)
+			for i, other := range result {
+				if other.date.After(date) {
+					pos = i
+					break
+				}
+			}
+			result = InsertIntoSliceAtIndex(result, b, pos)
+			known[b] = true
+		}
+		grouped[b] = append(grouped[b], item)
+	}
+	return result, grouped
+}
@@ -0,0 +1,89 @@
+package markdown
+
+import "fmt"
+
+type Status string
+
+// Statuses of a task with the marker of their checkbox
+const (
+	StatusOpen       Status = "open"        // [ ]
+	StatusInProgress Status = "in_progress" // [0]
+	StatusDone       Status = "done"        // [x]
+	StatusCancelled  Status = "cancelled"   // [-]
+	StatusDeferred   Status = "deferred"    // [>], moved to another day or month
+	StatusBlocked    Status = "blocked"     // [!]
+)
+
+var statusMarkers = map[Status]string{
+	StatusOpen:       " ",
+	StatusInProgress: "0",
+	StatusDone:       "x",
+	StatusCancelled:  "-",
+	StatusDeferred:   ">",
+	StatusBlocked:    "!",
+}
+
+// transitions lists the statuses a task can change to. A closed task has
+// to be reopened before it is worked on again.
+var transitions = map[Status][]Status{
+	StatusOpen:       {StatusInProgress, StatusDone, StatusCancelled, StatusDeferred, StatusBlocked},
+	StatusInProgress: {StatusOpen, StatusDone, StatusCancelled, StatusDeferred, StatusBlocked},
+	StatusBlocked:    {StatusOpen, StatusInProgress, StatusDone, StatusCancelled, StatusDeferred},
+	StatusDone:       {StatusOpen},
+	StatusCancelled:  {StatusOpen},
+	StatusDeferred:   {StatusOpen},
+}
+
+// parseStatus returns the status of a checkbox marker.
+func parseStatus(marker string) (Status, bool) {
+	if marker == "X" {
+		return StatusDone, true
+	}
+	for status, m := range statusMarkers {
+		if m == marker {
+			return status, true
+		}
+	}
+	return "", false
+}
+
+func (s Status) Valid() bool {
+	_, ok := statusMarkers[s]
+	return ok
+}
+
+// Closed reports whether no more work is planned for a task with the
+// status.
+func (s Status) Closed() bool {
+	return s == StatusDone || s == StatusCancelled || s == StatusDeferred
+}
+
+// InvalidTransitionError is returned if a task cannot change from its
+// status to another.
+type InvalidTransitionError struct {
+	Task string
+	From Status
+	To   Status
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("Task %q cannot change from %s to %s", e.Task, e.From, e.To)
+}
+
+// SetStatus changes the status of item, setting the current status again
+// is allowed.
+func (item *TodoItem) SetStatus(status Status) error {
+	if !status.Valid() {
+		return fmt.Errorf("Invalid status %q", status)
+	}
+	if item.Status == status {
+		return nil
+	}
+	for _, to := range transitions[item.Status] {
+		if to == status {
+			item.Status = status
+			return nil
+		}
+	}
+	return &InvalidTransitionError{item.Task, item.Status, status}
+}
@@ -0,0 +1,61 @@
+package markdown
+
+import "fmt"
+
+// Parent returns the task item is a subtask of, nil for top level items.
+func (item *TodoItem) Parent() *TodoItem {
+	return item.parent
+}
+
+// AddSubtask appends a subtask to parent and returns it.
+func (tl *TodoList) AddSubtask(parent *TodoItem, task string) *TodoItem {
+	if tl.ids == nil {
+		tl.ids = idSet{}
+		tl.walk(func(item *TodoItem) {
+			tl.ids.add(item.ID)
+		})
+	}
+
+	task, meta := parseMetadata(task)
+	child := &TodoItem{
+		ID:       tl.ids.next(),
+		Status:   StatusOpen,
+		Task:     task,
+		Day:      parent.Day,
+		Metadata: meta,
+		parent:   parent,
+	}
+	parent.Children = append(parent.Children, child)
+	return child
+}
+
+// FindSubtask returns the direct subtask of item matching task, see
+// findTask. It returns ErrTaskNotFound if no subtask matches.
+func (item *TodoItem) FindSubtask(task string) (*TodoItem, error) {
+	child, err := findTask(item.Children, task)
+	if err != nil {
+		return nil, err
+	}
+	if child == nil {
+		return nil, fmt.Errorf("%w: %s in %s", ErrTaskNotFound, task, item.Task)
+	}
+	return child, nil
+}
+
+// CompleteParents completes the parents of item whose subtasks are all
+// done or cancelled, from the innermost outwards. Parents with at least one
+// done subtask are completed, the others are left as they are.
+func (item *TodoItem) CompleteParents() {
+	for parent := item.parent; parent != nil && !parent.Status.Closed(); parent = parent.parent {
+		done := false
+		for _, child := range parent.Children {
+			if child.Status != StatusDone && child.Status != StatusCancelled {
+				return
+			}
+			done = done || child.Status == StatusDone
+		}
+		if !done || parent.Complete() != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAddSubtaskParsesBack(t *testing.T) {
+	tl, err := ParseMarkdownReader(strings.NewReader("## 10/2026\n- goals:\n- todos:\n    - 16.10:\n        - [ ] 1) parent <!-- id:aaaaaa -->\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := tl.GetTask("aaaaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := tl.AddSubtask(parent, "child one")
+
+	written := tl.String()
+	if !strings.Contains(written, "            - [ ] child one <!-- id:"+child.ID+" -->\n") {
+		t.Fatalf("subtask not written as open item:\n%s", written)
+	}
+	parsed, err := ParseMarkdownReader(strings.NewReader(written))
+	if err != nil {
+		t.Fatalf("written list does not parse: %v\n%s", err, written)
+	}
+	item, err := parsed.GetTask(child.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Status != StatusOpen || item.Task != "child one" || item.Parent() == nil || item.Parent().ID != "aaaaaa" {
+		t.Errorf("parsed subtask %+v, want open child one of aaaaaa", item)
+	}
+}
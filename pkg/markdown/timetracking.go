@@ -1,8 +1,9 @@
 package markdown
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"regexp"
 	"strconv"
@@ -14,24 +15,48 @@ import (
 
 type TimeTrackingList struct {
 	Months []*TimeTrackingMonth
+
+	// Original content of a parsed list, rendered as is where unchanged
+	src *documentSource
+	ids idSet
 }
 
 type TimeTrackingMonth struct {
 	Items []*TimeTrackingItem
 	Date  time.Time
+
+	src      *Source
+	srcDate  time.Time
+	timesSrc *Source
+	days     []*dayBlock
+	// Shared with the list
+	ids idSet
 }
 
 type TimeTrackingItem struct {
+	ID string
+	// TodoID is the ID of the tracked todo, empty if unknown
+	TodoID     string
 	InProgress bool
 	Task       string
 	Start      time.Time
 	End        time.Time
+
+	src       *itemSource
+	block     *dayBlock
+	srcMarker string
+	// Attributes of the comment not modeled by the item
+	attrs map[string]string
 }
 
 func (tl *TimeTrackingList) GetCurrentMonth() *TimeTrackingMonth {
-	today := time.Now()
+	return tl.GetMonth(time.Now())
+}
+
+// GetMonth returns the month of date, nil if the list has none.
+func (tl *TimeTrackingList) GetMonth(date time.Time) *TimeTrackingMonth {
 	for _, m := range tl.Months {
-		if today.Month() == m.Date.Month() {
+		if MonthEqual(m.Date, date) {
 			return m
 		}
 	}
@@ -39,6 +64,34 @@ func (tl *TimeTrackingList) GetCurrentMonth() *TimeTrackingMonth {
 	return nil
 }
 
+// AddMonth returns the month of date, adding it with empty times in
+// chronological order if missing.
+func (tl *TimeTrackingList) AddMonth(date time.Time) *TimeTrackingMonth {
+	if month := tl.GetMonth(date); month != nil {
+		return month
+	}
+
+	if tl.ids == nil {
+		tl.ids = idSet{}
+		for _, m := range tl.Months {
+			for _, item := range m.Items {
+				tl.ids.add(item.ID)
+			}
+		}
+	}
+	month := &TimeTrackingMonth{
+		Items: []*TimeTrackingItem{},
+		Date:  time.Date(date.Year(), date.Month(), 1, 1, 1, 0, 0, time.Local),
+		ids:   tl.ids,
+	}
+	index := monthIndex(len(tl.Months), func(i int) time.Time {
+		return tl.Months[i].Date
+	}, month.Date)
+
+	tl.Months = InsertIntoSliceAtIndex(tl.Months, month, index)
+	return month
+}
+
 func (tm *TimeTrackingMonth) GetTodaysTasks() []*TimeTrackingItem {
 	todaysTasks := make([]*TimeTrackingItem, 0)
 	now := time.Now()
@@ -51,9 +104,20 @@ func (tm *TimeTrackingMonth) GetTodaysTasks() []*TimeTrackingItem {
 	return todaysTasks
 }
 
-func (tm *TimeTrackingMonth) StartTodayTask(task string) {
+// StartTodayTask starts tracking task now and returns the new item.
+// todoID links it to the tracked todo, if known.
+func (tm *TimeTrackingMonth) StartTodayTask(task, todoID string) *TimeTrackingItem {
+	if tm.ids == nil {
+		tm.ids = idSet{}
+		for _, item := range tm.Items {
+			tm.ids.add(item.ID)
+		}
+	}
+
 	todaysTasks := tm.GetTodaysTasks()
 	newItem := &TimeTrackingItem{
+		ID:         tm.ids.next(),
+		TodoID:     todoID,
 		Task:       task,
 		Start:      time.Now(),
 		InProgress: true,
@@ -62,7 +126,7 @@ func (tm *TimeTrackingMonth) StartTodayTask(task string) {
 		index := 0
 		target := todaysTasks[len(todaysTasks)-1]
 		for i, v := range tm.Items {
-			if target.Task == v.Task && DayEqual(v.Start, target.Start) {
+			if v == target {
 				index = i
 				break
 			}
@@ -73,189 +137,258 @@ func (tm *TimeTrackingMonth) StartTodayTask(task string) {
 		tm.Items = append(tm.Items, newItem)
 	}
 
+	return newItem
 }
 
-func (tm *TimeTrackingMonth) CompleteTodayTask(task string) bool {
-	today := time.Now()
-	for _, item := range tm.Items {
-		if !DayEqual(item.Start, today) {
+// RunningTodayTask returns the latest tracking of today still in progress
+// for the todo with todoID, or for task if the tracking is not linked to a
+// todo, nil if there is none.
+func (tm *TimeTrackingMonth) RunningTodayTask(task, todoID string) *TimeTrackingItem {
+	var tracked *TimeTrackingItem
+	for _, item := range tm.GetTodaysTasks() {
+		if !item.InProgress {
 			continue
 		}
-
-		logrus.Warn("Checking ", item.Task, " to match ", task, " with result ", strings.Index(item.Task, task))
-		if strings.Index(item.Task, task) >= 0 {
-			item.InProgress = false
-			item.End = time.Now()
-			// Since these are not pointers we need to update the element
-			// tm.Items[index] = item
-			return false
+		if item.TodoID != "" && item.TodoID == todoID || item.TodoID == "" && item.Task == task {
+			tracked = item
 		}
 	}
-	return true
+	return tracked
 }
 
+// CompleteTodayTask stops the tracking returned by RunningTodayTask and
+// returns it, nil if there is none.
+func (tm *TimeTrackingMonth) CompleteTodayTask(task, todoID string) *TimeTrackingItem {
+	tracked := tm.RunningTodayTask(task, todoID)
+	if tracked == nil {
+		return nil
+	}
+
+	tracked.InProgress = false
+	tracked.End = time.Now()
+	return tracked
+}
+
+// duration returns the tracked time, up to now if still in progress.
+func (item *TimeTrackingItem) duration() time.Duration {
+	if item.InProgress {
+		return time.Since(item.Start)
+	}
+	return item.End.Sub(item.Start)
+}
+
+// allAttrs returns the attributes stored in the comment of the item.
+func (item *TimeTrackingItem) allAttrs() map[string]string {
+	attrs := otherAttrs(item.attrs)
+	attrs[attrID] = item.ID
+	attrs[attrTodo] = item.TodoID
+	return attrs
+}
+
+// WriteToFile atomically replaces file with the time tracking list.
 func (tl *TimeTrackingList) WriteToFile(file string) error {
-	str := ""
-	curDay := 0
+	return writeFileAtomic(file, tl)
+}
+
+func (tl *TimeTrackingList) String() string {
+	var b strings.Builder
+	// Writing to a strings.Builder does not fail
+	tl.WriteTo(&b)
+	return b.String()
+}
+
+// WriteTo streams the time tracking list as markdown to out. Content of
+// a parsed list is kept byte for byte, only lines of changed items are
+// rewritten.
+func (tl *TimeTrackingList) WriteTo(out io.Writer) (int64, error) {
+	w := newLineWriter(out)
+	w.begin(tl.src)
+
 	for _, month := range tl.Months {
-		// Write month line
-		str += fmt.Sprintf("## %s/%d\n", appendZeroIfMissing(int(month.Date.Month())), month.Date.Year())
-		// write -TimeTrackings
-		str += "- times:\n"
-		// Render all TimeTrackings
-		for _, item := range month.Items {
-			if item.Start.Day() != curDay { // Add new day
-				str += fmt.Sprintf("    - %s.%s:\n", appendZeroIfMissing(item.Start.Day()), appendZeroIfMissing(int(item.Start.Month())))
-				curDay = item.Start.Day()
-			}
+		heading := fmt.Sprintf("## %s/%d", appendZeroIfMissing(int(month.Date.Month())), month.Date.Year())
+		if month.src != nil && month.Date.Equal(month.srcDate) {
+			heading = month.src.Raw
+		}
+		w.node(month.src, heading)
 
-			if item.InProgress {
-				str += fmt.Sprintf("        - [%s:%s-] %s\n", appendZeroIfMissing(item.Start.Hour()), appendZeroIfMissing(item.Start.Minute()), item.Task)
-			} else {
-				str += fmt.Sprintf("        - [%s:%s-%s:%s] %s\n", appendZeroIfMissing(item.Start.Hour()), appendZeroIfMissing(item.Start.Minute()), appendZeroIfMissing(item.End.Hour()), appendZeroIfMissing(item.End.Minute()), item.Task)
+		if month.src == nil || month.timesSrc != nil || len(month.Items) > 0 {
+			writeSection(w, month.timesSrc, "times")
+		}
+		days, items := groupByDay(month.days, month.Items,
+			func(item *TimeTrackingItem) time.Time { return item.Start },
+			func(item *TimeTrackingItem) *dayBlock { return item.block })
+		for _, day := range days {
+			writeDay(w, day)
+			for _, item := range items[day] {
+				item.write(w)
 			}
 		}
 	}
 
-	f, err := os.Create(file)
-	if err != nil {
-		return err
-	}
+	return w.end(tl.src)
+}
 
-	_, err = f.WriteString(str)
-	if err != nil {
-		return err
+// marker returns the time range of the item.
+func (item *TimeTrackingItem) marker() string {
+	start := fmt.Sprintf("%s:%s", appendZeroIfMissing(item.Start.Hour()), appendZeroIfMissing(item.Start.Minute()))
+	if item.InProgress {
+		return start + "-"
 	}
+	return fmt.Sprintf("%s-%s:%s", start, appendZeroIfMissing(item.End.Hour()), appendZeroIfMissing(item.End.Minute()))
+}
 
-	return nil
+func (item *TimeTrackingItem) write(w *lineWriter) {
+	attrs := attrsComment(item.allAttrs())
+	if item.src == nil {
+		w.write(itemLine("        ", item.marker(), item.Task, attrs))
+		return
+	}
+	// Keep the spacing of unchanged ranges
+	marker := item.marker()
+	changed := marker != strings.ReplaceAll(item.srcMarker, " ", "")
+	if !changed {
+		marker = item.srcMarker
+	}
+	w.node(item.src.Source, item.src.line(marker, changed, item.Task, attrs))
 }
 
 func ParseTimeTrackingMarkdown(file string) (*TimeTrackingList, error) {
-
-	tl := &TimeTrackingList{}
 	readFile, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer readFile.Close()
 
+	tl, err := ParseTimeTrackingMarkdownReader(readFile)
+	var parseErr *ParseErrors
+	if errors.As(err, &parseErr) {
+		parseErr.File = file
+	}
+	return tl, err
+}
+
+// ParseTimeTrackingMarkdownReader parses a time tracking list, all
+// problems found are returned as *ParseErrors.
+func ParseTimeTrackingMarkdownReader(r io.Reader) (*TimeTrackingList, error) {
+	tokens, finalNewline, err := tokenize(r)
 	if err != nil {
 		return nil, err
 	}
-	fileScanner := bufio.NewScanner(readFile)
-
-	fileScanner.Split(bufio.ScanLines)
-	// TimeTracking: Year
-	curDay := time.Now()
-	curYear := time.Now()
-	curYearInt := 0
-	var timeTrackingMonth *TimeTrackingMonth = nil
-
-	for fileScanner.Scan() {
-		line := fileScanner.Text()
-		if line == "" {
-			continue
-		}
-		if strings.Index(line, "##") == 0 { // New Month
-			if timeTrackingMonth != nil {
-				tl.Months = append(tl.Months, timeTrackingMonth)
-			}
 
-			re, _ := regexp.Compile("##.*\\s(.*)\\/(.*)")
-			matches := re.FindAllStringSubmatch(line, -1)
-			for _, match := range matches {
-				fmt.Printf("key=%s, value=%s\n", match[1], match[2])
-				curYearInt, _ = strconv.Atoi(match[2])
-				if strings.Index(match[1], "0") == 0 {
-					match[1] = strings.ReplaceAll(match[1], "0", "")
-				}
-				month, _ := strconv.Atoi(match[1])
-				curYear = time.Date(curYearInt, time.Month(month), 1, 1, 1, 0, 0, time.Local)
-				fmt.Println("Setting new year to ", curYearInt, " and month ", month)
-				break
-			}
+	doc, errs := parseDocument(tokens, finalNewline)
+	tl, buildErrs := buildTimeTrackingList(doc)
+	if err := parseErrors(append(errs, buildErrs...)); err != nil {
+		return nil, err
+	}
+	return tl, nil
+}
 
-			timeTrackingMonth = &TimeTrackingMonth{
-				Items: []*TimeTrackingItem{},
-				Date:  curYear,
-			}
-		}
+var timeRangeRegex = regexp.MustCompile(`^\s*(\d{1,2}):(\d{2})\s*-\s*(?:(\d{1,2}):(\d{2}))?\s*$`)
+
+func buildTimeTrackingList(doc *Document) (*TimeTrackingList, []*ParseError) {
+	tl := &TimeTrackingList{
+		src: newDocumentSource(doc),
+		ids: idSet{},
+	}
+	errs := []*ParseError{}
+
+	for _, section := range doc.Sections {
+		errs = append(errs, errorf(section.Pos, "section %q outside of a month", section.Name))
+	}
 
-		if strings.Index(line, "- TimeTrackings") >= 0 { // Add TimeTrackings to month
+	for _, m := range doc.Months {
+		// Broken months are reported by the parser already
+		if m.Month == 0 {
 			continue
 		}
+		month := &TimeTrackingMonth{
+			Items: []*TimeTrackingItem{},
+			Date:  time.Date(m.Year, m.Month, 1, 1, 1, 0, 0, time.Local),
+			src:   m.Src,
+			ids:   tl.ids,
+		}
+		month.srcDate = month.Date
 
-		if strings.Index(line, "- [") == -1 { // New date
-			re, _ := regexp.Compile(".*\\s(.*)\\.(.*)\\:.*")
-			matches := re.FindAllStringSubmatch(line, -1)
-			for _, match := range matches {
-				fmt.Printf("key=%s, value=%s\n", match[1], match[2])
-				if strings.Index(match[1], "0") == 0 {
-					match[1] = strings.ReplaceAll(match[1], "0", "")
-				}
-				month, _ := strconv.Atoi(match[2])
-				if strings.Index(match[2], "0") == 0 {
-					match[2] = strings.ReplaceAll(match[2], "0", "")
-				}
-				day, _ := strconv.Atoi(match[1])
-				curDay = time.Date(2023, time.Month(month), day, 0, 0, 0, 0, time.Local)
-				break
+		for _, section := range m.Sections {
+			if section.Name != "times" {
+				errs = append(errs, errorf(section.Pos, "unknown section %q, expected times", section.Name))
+				continue
 			}
-		} else {
-			/*
-				## 01/2023
-				- times:
-					- 09.01:
-						- [09:00-10:30] 8) Kollegefrechdachs meeting
-						- [10:30-11:00] Duschen
-						- [11:00-12:30] Telegram TimeTracking integration
-						- [13:00-14:00] Complete undeployed telegram TimeTracking integration
-					- 10.01:
-						- [09:00-10:00] 7) Complete Hercules slides
-						- [10:00-] 6) Tofino Meeting
-			*/
-			res := strings.Trim(line, "")
-			inProgress := strings.Index(line, "-]") >= 0
-			parts := strings.Split(res, "] ")
-			times := strings.ReplaceAll(parts[0], "- [", "")
-			logrus.Warn(times)
-			timeParts := strings.Split(times, "-")
-			start, end := startEndTimeFromString(curYearInt, int(timeTrackingMonth.Date.Month()), curDay.Day(), timeParts)
-
-			task := parts[1]
-			td := &TimeTrackingItem{
-				InProgress: inProgress,
-				Task:       task,
-				Start:      start,
+			for _, item := range section.Items {
+				errs = append(errs, errorf(item.Pos, "time tracking %q without day", item.Text))
 			}
-			if end != nil {
-				td.End = *end
+			month.timesSrc = section.Src
+			for _, d := range section.Days {
+				if d.Day == 0 {
+					continue
+				}
+				block := newDayBlock(time.Date(d.Year, d.Month, d.Day, 0, 0, 0, 0, time.Local), d.Src)
+				month.days = append(month.days, block)
+				// Time trackings are not nested, indented items are
+				// kept in order as they are
+				for _, node := range flattenItems(d.Items) {
+					item, err := buildTimeTrackingItem(node, d)
+					if err != nil {
+						errs = append(errs, err)
+						continue
+					}
+					item.block = block
+					month.Items = append(month.Items, item)
+					tl.ids.add(item.ID)
+				}
 			}
-			timeTrackingMonth.Items = append(timeTrackingMonth.Items, td)
-
 		}
-	}
 
-	tl.Months = append(tl.Months, timeTrackingMonth)
+		tl.Months = append(tl.Months, month)
+	}
 
-	readFile.Close()
-	return tl, nil
+	return tl, errs
+}
 
+func flattenItems(nodes []*ItemNode) []*ItemNode {
+	flat := []*ItemNode{}
+	for _, node := range nodes {
+		flat = append(flat, node)
+		flat = append(flat, flattenItems(node.Children)...)
+	}
+	return flat
 }
 
-func startEndTimeFromString(year int, month int, day int, timeParts []string) (time.Time, *time.Time) {
-	startParts := strings.Split(strings.Trim(timeParts[0], " "), ":")
-	endParts := strings.Split(strings.Trim(timeParts[1], " "), ":")
-
-	logrus.Warn(startParts)
-	logrus.Warn(endParts)
-	logrus.Warn(timeParts[1])
-	startHour, _ := strconv.Atoi(startParts[0])
-	startMinute, _ := strconv.Atoi(startParts[1])
-	start := time.Date(year, time.Month(month), day, startHour, startMinute, 0, 0, time.Local)
-	if len(endParts) < 2 {
-		return start, nil
+func buildTimeTrackingItem(node *ItemNode, day *DayNode) (*TimeTrackingItem, *ParseError) {
+	invalid := errorf(node.MarkerPos, "invalid time range [%s], expected [HH:MM-HH:MM] or [HH:MM-]", node.Marker)
+	match := timeRangeRegex.FindStringSubmatch(node.Marker)
+	if match == nil {
+		return nil, invalid
 	}
-	endHour, _ := strconv.Atoi(endParts[0])
-	endMinute, _ := strconv.Atoi(endParts[1])
 
-	end := time.Date(year, time.Month(month), day, endHour, endMinute, 0, 0, time.Local)
+	clock := func(hourStr, minuteStr string) (time.Time, bool) {
+		hour, _ := strconv.Atoi(hourStr)
+		minute, _ := strconv.Atoi(minuteStr)
+		if hour > 23 || minute > 59 {
+			return time.Time{}, false
+		}
+		return time.Date(day.Year, day.Month, day.Day, hour, minute, 0, 0, time.Local), true
+	}
 
-	return start, &end
+	start, ok := clock(match[1], match[2])
+	if !ok {
+		return nil, invalid
+	}
+	item := &TimeTrackingItem{
+		ID:         node.Attrs[attrID],
+		TodoID:     node.Attrs[attrTodo],
+		attrs:      otherAttrs(node.Attrs, attrID, attrTodo),
+		Task:       node.Text,
+		Start:      start,
+		InProgress: match[3] == "",
+		src:        newItemSource(node),
+		srcMarker:  node.Marker,
+	}
+	if !item.InProgress {
+		item.End, ok = clock(match[3], match[4])
+		if !ok {
+			return nil, invalid
+		}
+	}
+	return item, nil
 }
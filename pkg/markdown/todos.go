@@ -1,10 +1,10 @@
 package markdown
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -15,19 +15,56 @@ import (
 type TodoList struct {
 	Goals  []*TodoItem
 	Months []*TodoMonth
+	// Numbered writes the tasks of a day as numbered list, days whose
+	// list changed are renumbered. Parsed lists are numbered if any task
+	// of a day has a number.
+	Numbered bool
+
+	// Original content of a parsed list, rendered as is where unchanged
+	src      *documentSource
+	goalsSrc *Source
+	ids      idSet
 }
 
 type TodoMonth struct {
 	Goals []*TodoItem
 	Items []*TodoItem
 	Date  time.Time
+
+	src      *Source
+	srcDate  time.Time
+	goalsSrc *Source
+	todosSrc *Source
+	days     []*dayBlock
+	// Shared with the list
+	ids idSet
 }
 
 type TodoItem struct {
-	Done       bool
-	InProgress bool
-	Task       string
-	Day        time.Time
+	// ID is stable across edits, empty for items never changed by the
+	// service
+	ID     string
+	Status Status
+	Task   string
+	Day    time.Time
+	Metadata
+	// Notes are lines of free text below the task
+	Notes string
+	// Subtasks, planned for the day of the item
+	Children []*TodoItem
+
+	parent    *TodoItem
+	src       *itemSource
+	block     *dayBlock
+	srcMarker string
+	// Task and metadata of the parsed line in canonical form
+	srcText  string
+	srcNotes string
+	// Number and position of a task of a day when parsed
+	srcNumber int
+	srcIndex  int
+	// Attributes of the comment not modeled by the item
+	attrs map[string]string
 }
 
 func (tm *TodoMonth) GetTodaysTasks() []*TodoItem {
@@ -42,68 +79,136 @@ func (tm *TodoMonth) GetTodaysTasks() []*TodoItem {
 	return todaysTasks
 }
 
-func (tm *TodoMonth) GetFullTask(task string) *TodoItem {
-	today := time.Now()
-	for _, item := range tm.Items {
-		if !DayEqual(item.Day, today) {
-			continue
-		}
+// FindTodayTask returns the task of today matching task, see findTask.
+// It returns nil if no task matches or contains task.
+func (tm *TodoMonth) FindTodayTask(task string) (*TodoItem, error) {
+	return findTask(tm.GetTodaysTasks(), task)
+}
 
-		logrus.Warn("Checking ", item.Task, " to match ", task, " with result ", strings.Index(item.Task, task))
-		if strings.Index(item.Task, task) >= 0 {
-			return item
-		}
+// StartTodayTask marks the matching task of today as in progress, or adds
+// it as in progress, and returns it.
+func (tm *TodoMonth) StartTodayTask(task string) (*TodoItem, error) {
+	item, err := tm.FindTodayTask(task)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	if item == nil {
+		return tm.AddTodayTask(task, StatusInProgress), nil
+	}
+
+	if err := item.Start(); err != nil {
+		return nil, err
+	}
+	tm.assignID(item)
+	return item, nil
 }
 
-func (tm *TodoMonth) StartTodayTask(task string) bool {
-	today := time.Now()
-	for _, item := range tm.Items {
-		if !DayEqual(item.Day, today) {
-			continue
-		}
+// CompleteTodayTask completes the matching task of today, or adds it as
+// completed, and returns it.
+func (tm *TodoMonth) CompleteTodayTask(task string) (*TodoItem, error) {
+	item, err := tm.FindTodayTask(task)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return tm.AddTodayTask(task, StatusDone), nil
+	}
 
-		logrus.Warn("Checking ", item.Task, " to match ", task, " with result ", strings.Index(item.Task, task))
-		if strings.Index(item.Task, task) >= 0 {
-			item.InProgress = true
-			// Since these are not pointers we need to update the element
-			// tm.Items[index] = item
-			return false
-		}
+	if err := item.Complete(); err != nil {
+		return nil, err
+	}
+	tm.assignID(item)
+	return item, nil
+}
+
+// SetTodayTaskStatus changes the status of the matching task of today and
+// returns it, or ErrTaskNotFound if there is none.
+func (tm *TodoMonth) SetTodayTaskStatus(task string, status Status) (*TodoItem, error) {
+	item, err := tm.FindTodayTask(task)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, fmt.Errorf("%w: %s", ErrTaskNotFound, task)
 	}
 
-	// Just add and complete it
-	tm.AddTodayTask(task, false, true)
-	return true
+	if err := item.SetStatus(status); err != nil {
+		return nil, err
+	}
+	tm.assignID(item)
+	return item, nil
 }
 
-func (tm *TodoMonth) CompleteTodayTask(task string) bool {
-	today := time.Now()
-	for _, item := range tm.Items {
-		if !DayEqual(item.Day, today) {
-			continue
-		}
+func (item *TodoItem) Start() error {
+	return item.SetStatus(StatusInProgress)
+}
+
+func (item *TodoItem) Complete() error {
+	return item.SetStatus(StatusDone)
+}
 
-		logrus.Warn("Checking ", item.Task, " to match ", task, " with result ", strings.Index(item.Task, task))
-		if strings.Index(item.Task, task) >= 0 {
-			item.Done = true
-			item.InProgress = false
-			// Since these are not pointers we need to update the element
-			// tm.Items[index] = item
-			return false
+// assignID gives item an ID if it has none, once the service changes it.
+func (tm *TodoMonth) assignID(item *TodoItem) {
+	if tm.ids == nil {
+		tm.ids = idSet{}
+		walkItems(tm.Items, func(item *TodoItem) {
+			tm.ids.add(item.ID)
+		})
+	}
+	if item.ID == "" {
+		item.ID = tm.ids.next()
+	}
+}
+
+// assignChangedIDs gives every item created or changed since the list was
+// parsed an ID, goals and subtasks included. Lines of untouched items are
+// kept as they are.
+func (tl *TodoList) assignChangedIDs() {
+	if tl.ids == nil {
+		tl.ids = idSet{}
+		tl.walk(func(item *TodoItem) {
+			tl.ids.add(item.ID)
+		})
+	}
+	tl.walk(func(item *TodoItem) {
+		if item.ID == "" && item.changed() {
+			item.ID = tl.ids.next()
 		}
+	})
+}
+
+// changed reports whether the status, text or notes of item differ from
+// the parsed line, or whether it is new. Renumbering does not count.
+func (item *TodoItem) changed() bool {
+	if item.src == nil {
+		return true
 	}
+	text := strings.TrimPrefix(item.srcText, numberPrefix(item.srcNumber))
+	return item.marker() != markerStatus(item.srcMarker) ||
+		withMetadata(item.Task, item.Metadata) != text ||
+		item.Notes != item.srcNotes
+}
 
-	// Just add and complete it
-	tm.AddTodayTask(task, true, false)
-	return true
+// allAttrs returns the attributes stored in the comment of the item.
+func (item *TodoItem) allAttrs() map[string]string {
+	attrs := otherAttrs(item.attrs)
+	attrs[attrID] = item.ID
+	return attrs
+}
+
+// marker returns the checkbox marker of the item.
+func (item *TodoItem) marker() string {
+	return statusMarkers[item.Status]
 }
 
 func DayEqual(v, today time.Time) bool {
 	return v.Day() == today.Day() && v.Month() == today.Month() && v.Year() == today.Year()
 }
 
+func MonthEqual(v, other time.Time) bool {
+	return v.Month() == other.Month() && v.Year() == other.Year()
+}
+
 /*func insertTodoItem(list []*TodoItem, c *TodoItem, i int) []*TodoItem {
 	if i == len(list)-1 {
 		return append(list, c)
@@ -122,40 +227,91 @@ func InsertIntoSliceAtIndex[T any](destination []T, element T, index int) []T {
 	return destination
 }
 
-func (tm *TodoMonth) AddTodayTask(task string, completed bool, inProgress bool) {
+// AddTodayTask appends task to the tasks of today and returns it. A
+// number given in task is dropped, tasks are numbered when written.
+func (tm *TodoMonth) AddTodayTask(task string, status Status) *TodoItem {
+	_, task = splitNumber(task)
+	task, meta := parseMetadata(task)
+	newItem := &TodoItem{
+		Status:   status,
+		Task:     task,
+		Day:      time.Now(),
+		Metadata: meta,
+	}
+	tm.insertTask(newItem)
 
-	// TODO: Not numbered tasks
+	tm.assignID(newItem)
+	return newItem
+}
 
-	todaysTasks := tm.GetTodaysTasks()
-	num := len(todaysTasks) + 1
-	newItem := &TodoItem{
-		Done:       completed,
-		Task:       fmt.Sprintf("%d) %s", num, task),
-		Day:        time.Now(),
-		InProgress: inProgress,
-	}
-	if len(todaysTasks) > 0 {
-		index := 0
-		target := todaysTasks[len(todaysTasks)-1]
-		for i, v := range tm.Items {
-			if target.Task == v.Task && DayEqual(v.Day, target.Day) {
-				index = i
-				break
-			}
+// insertTask inserts item after the last task of its day.
+func (tm *TodoMonth) insertTask(item *TodoItem) {
+	var target *TodoItem
+	for _, v := range tm.Items {
+		if DayEqual(v.Day, item.Day) {
+			target = v
+		}
+	}
+	if target == nil {
+		tm.Items = append(tm.Items, item)
+		return
+	}
+
+	index := 0
+	for i, v := range tm.Items {
+		if v == target {
+			index = i
+			break
 		}
-		logrus.Info("Inserting at index ", index+1)
-		tm.Items = InsertIntoSliceAtIndex(tm.Items, newItem, index+1)
-	} else {
-		tm.Items = append(tm.Items, newItem)
 	}
+	logrus.Info("Inserting at index ", index+1)
+	tm.Items = InsertIntoSliceAtIndex(tm.Items, item, index+1)
+}
 
-	// tm.Items = append(tm.Items)
+// walk calls fn for every item of the list, goals and subtasks included.
+func (tl *TodoList) walk(fn func(item *TodoItem)) {
+	walkItems(tl.Goals, fn)
+	for _, m := range tl.Months {
+		walkItems(m.Goals, fn)
+		walkItems(m.Items, fn)
+	}
+}
+
+func walkItems(items []*TodoItem, fn func(item *TodoItem)) {
+	for _, item := range items {
+		fn(item)
+		walkItems(item.Children, fn)
+	}
+}
+
+// GetTask returns the item with id, goals and subtasks included. It returns
+// ErrTaskNotFound if there is none and *AmbiguousTaskError if the ID was
+// copied to several items by hand.
+func (tl *TodoList) GetTask(id string) (*TodoItem, error) {
+	matches := []*TodoItem{}
+	tl.walk(func(item *TodoItem) {
+		if item.ID == id {
+			matches = append(matches, item)
+		}
+	})
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", ErrTaskNotFound, id)
+	case 1:
+		return matches[0], nil
+	}
+	return nil, &AmbiguousTaskError{Query: id, Matches: matches}
 }
 
 func (tl *TodoList) GetCurrentMonth() *TodoMonth {
-	today := time.Now()
+	return tl.GetMonth(time.Now())
+}
+
+// GetMonth returns the month of date, nil if the list has none.
+func (tl *TodoList) GetMonth(date time.Time) *TodoMonth {
 	for _, m := range tl.Months {
-		if today.Month() == m.Date.Month() {
+		if MonthEqual(m.Date, date) {
 			return m
 		}
 	}
@@ -163,6 +319,66 @@ func (tl *TodoList) GetCurrentMonth() *TodoMonth {
 	return nil
 }
 
+// AddMonth returns the month of date, adding it with empty goals and
+// todos in chronological order if missing. If carryOverGoals is set, the
+// unfinished goals of the month before are copied to a new month.
+func (tl *TodoList) AddMonth(date time.Time, carryOverGoals bool) *TodoMonth {
+	if month := tl.GetMonth(date); month != nil {
+		return month
+	}
+
+	if tl.ids == nil {
+		tl.ids = idSet{}
+		tl.walk(func(item *TodoItem) {
+			tl.ids.add(item.ID)
+		})
+	}
+	month := &TodoMonth{
+		Goals: []*TodoItem{},
+		Items: []*TodoItem{},
+		Date:  time.Date(date.Year(), date.Month(), 1, 1, 1, 0, 0, time.Local),
+		ids:   tl.ids,
+	}
+	index := monthIndex(len(tl.Months), func(i int) time.Time {
+		return tl.Months[i].Date
+	}, month.Date)
+
+	if carryOverGoals && index > 0 {
+		for _, goal := range tl.Months[index-1].Goals {
+			if goal.Status == StatusDone || goal.Status == StatusCancelled {
+				continue
+			}
+			// Deferred goals are picked up again
+			status := goal.Status
+			if status == StatusDeferred {
+				status = StatusOpen
+			}
+			month.Goals = append(month.Goals, &TodoItem{
+				ID:       tl.ids.next(),
+				Status:   status,
+				Task:     goal.Task,
+				Day:      month.Date,
+				Metadata: goal.Metadata,
+				Notes:    goal.Notes,
+			})
+		}
+	}
+
+	tl.Months = InsertIntoSliceAtIndex(tl.Months, month, index)
+	return month
+}
+
+// monthIndex returns the index a month of date is inserted at to keep n
+// months in chronological order.
+func monthIndex(n int, dateAt func(i int) time.Time, date time.Time) int {
+	for i := 0; i < n; i++ {
+		if dateAt(i).After(date) {
+			return i
+		}
+	}
+	return n
+}
+
 func appendZeroIfMissing(val int) string {
 	str := fmt.Sprintf("%d", val)
 	if len(str) == 1 {
@@ -172,158 +388,332 @@ func appendZeroIfMissing(val int) string {
 	return str
 }
 
+// WriteToFile atomically replaces file with the todo list. Items changed
+// without ID get one, so that every changed item is addressable.
 func (tl *TodoList) WriteToFile(file string) error {
-	str := ""
-	curDay := 0
+	tl.assignChangedIDs()
+	return writeFileAtomic(file, tl)
+}
+
+func (tl *TodoList) String() string {
+	var b strings.Builder
+	// Writing to a strings.Builder does not fail
+	tl.WriteTo(&b)
+	return b.String()
+}
+
+// WriteTo streams the todo list as markdown to out. Content of a parsed
+// list is kept byte for byte, only lines of changed items are rewritten.
+func (tl *TodoList) WriteTo(out io.Writer) (int64, error) {
+	w := newLineWriter(out)
+	w.begin(tl.src)
+
+	// Goals of the year come before the first month
+	if len(tl.Goals) > 0 || tl.goalsSrc != nil {
+		writeSection(w, tl.goalsSrc, "goals")
+		writeItems(w, tl.Goals, "    ", nil)
+	}
+
 	for _, month := range tl.Months {
-		// Write month line
-		str += fmt.Sprintf("## %s/%d\n", appendZeroIfMissing(int(month.Date.Month())), month.Date.Year())
-		// write -goals
-		str += "- goals:\n"
-		// Render all goals
-		for _, goal := range month.Goals {
-			if goal.Done {
-				str += fmt.Sprintf("    - [x] %s\n", goal.Task)
-			} else if goal.InProgress {
-				str += fmt.Sprintf("    - [0] %s\n", goal.Task)
-			} else {
-				str += fmt.Sprintf("    - [ ] %s\n", goal.Task)
-			}
+		heading := fmt.Sprintf("## %s/%d", appendZeroIfMissing(int(month.Date.Month())), month.Date.Year())
+		if month.src != nil && month.Date.Equal(month.srcDate) {
+			heading = month.src.Raw
 		}
-		// write -todos
-		str += "- todos:\n"
-		// Render all todos
-		for _, todo := range month.Items {
-			if todo.Day.Day() != curDay { // Add new day
-				str += fmt.Sprintf("    - %s.%s:\n", appendZeroIfMissing(todo.Day.Day()), appendZeroIfMissing(int(todo.Day.Month())))
-				curDay = todo.Day.Day()
-			}
+		w.node(month.src, heading)
+
+		// New months always get both sections
+		if month.src == nil || month.goalsSrc != nil || len(month.Goals) > 0 {
+			writeSection(w, month.goalsSrc, "goals")
+		}
+		writeItems(w, month.Goals, "    ", nil)
+
+		if month.src == nil || month.todosSrc != nil || len(month.Items) > 0 {
+			writeSection(w, month.todosSrc, "todos")
+		}
+		days, items := groupByDay(month.days, month.Items,
+			func(item *TodoItem) time.Time { return item.Day },
+			func(item *TodoItem) *dayBlock { return item.block })
+		for _, day := range days {
+			writeDay(w, day)
+			writeItems(w, items[day], "        ", dayNumbers(day, items[day], tl.Numbered))
+		}
+	}
 
-			if todo.Done {
-				str += fmt.Sprintf("        - [x] %s\n", todo.Task)
-			} else if todo.InProgress {
-				str += fmt.Sprintf("        - [0] %s\n", todo.Task)
-			} else {
-				str += fmt.Sprintf("        - [ ] %s\n", todo.Task)
+	return w.end(tl.src)
+}
+
+func writeSection(w *lineWriter, src *Source, name string) {
+	if src != nil {
+		w.node(src, src.Raw)
+		return
+	}
+	w.line("- %s:", name)
+}
+
+func writeDay(w *lineWriter, day *dayBlock) {
+	if day.src != nil {
+		w.node(day.src, day.src.Raw)
+		return
+	}
+	w.line("    - %s.%s:", appendZeroIfMissing(day.date.Day()), appendZeroIfMissing(int(day.date.Month())))
+}
+
+// writeItems writes items and their children. New items are indented like
+// the parsed item before them, or by indent. Items are prefixed with their
+// numbers, if given.
+func writeItems(w *lineWriter, items []*TodoItem, indent string, numbers []int) {
+	for i, item := range items {
+		if item.src != nil {
+			indent = item.src.indent
+		}
+		number := 0
+		if numbers != nil {
+			number = numbers[i]
+		}
+		item.write(w, indent, number)
+		writeItems(w, item.Children, indent+"    ", nil)
+	}
+}
+
+// dayNumbers returns the numbers the tasks of day are written with. If the
+// list of the day changed, by adding, removing or moving tasks, they are
+// renumbered. Otherwise they keep the numbers they were parsed with.
+func dayNumbers(day *dayBlock, items []*TodoItem, numbered bool) []int {
+	numbers := make([]int, len(items))
+	changed := len(items) != day.items
+	for i, item := range items {
+		numbers[i] = item.srcNumber
+		changed = changed || item.src == nil || item.block != day || item.srcIndex != i
+	}
+	if changed {
+		for i := range numbers {
+			numbers[i] = 0
+			if numbered {
+				numbers[i] = i + 1
 			}
 		}
 	}
+	return numbers
+}
 
-	f, err := os.Create(file)
-	if err != nil {
-		return err
+// numberPrefix returns the prefix of a task with number, empty for 0.
+func numberPrefix(number int) string {
+	if number == 0 {
+		return ""
 	}
+	return fmt.Sprintf("%d) ", number)
+}
 
-	_, err = f.WriteString(str)
-	if err != nil {
-		return err
+// splitNumber splits a prefix like "2) " off task, the number is 0 if
+// there is none.
+func splitNumber(task string) (int, string) {
+	prefix := taskNumberRegex.FindString(task)
+	if prefix == "" {
+		return 0, task
 	}
+	number, err := strconv.Atoi(prefix[:strings.Index(prefix, ")")])
+	// A number alone is the task
+	if err != nil || number == 0 || len(prefix) == len(task) {
+		return 0, task
+	}
+	return number, task[len(prefix):]
+}
 
-	return nil
+func (item *TodoItem) write(w *lineWriter, indent string, number int) {
+	attrs := attrsComment(item.allAttrs())
+	text := numberPrefix(number) + withMetadata(item.Task, item.Metadata)
+	notes := item.noteLines(indent)
+	if item.src == nil {
+		w.nodeNotes(nil, itemLine(indent, item.marker(), text, attrs), notes)
+		return
+	}
+	// Keep the order and spacing of unchanged metadata
+	if text == item.srcText {
+		text = item.src.text
+	}
+	marker := item.marker()
+	// Keep markers like X for done items
+	changed := marker != markerStatus(item.srcMarker)
+	if !changed {
+		marker = item.srcMarker
+	}
+	w.nodeNotes(item.src.Source, item.src.line(marker, changed, text, attrs), notes)
 }
 
-func ParseMarkdown(file string) (*TodoList, error) {
+// markerStatus normalizes a checkbox marker.
+func markerStatus(marker string) string {
+	if marker == "X" {
+		return "x"
+	}
+	return marker
+}
 
-	tl := &TodoList{}
+func ParseMarkdown(file string) (*TodoList, error) {
 	readFile, err := os.Open(file)
-
 	if err != nil {
 		return nil, err
 	}
-	fileScanner := bufio.NewScanner(readFile)
+	defer readFile.Close()
 
-	fileScanner.Split(bufio.ScanLines)
-	// TODO: Year
-	curDay := time.Now()
-	curYear := time.Now()
-	curYearInt := 0
-	var todoMonth *TodoMonth = nil
-	goalsMode := true
+	tl, err := ParseMarkdownReader(readFile)
+	var parseErr *ParseErrors
+	if errors.As(err, &parseErr) {
+		parseErr.File = file
+	}
+	return tl, err
+}
 
-	for fileScanner.Scan() {
-		line := fileScanner.Text()
-		if line == "" {
-			continue
-		}
-		if strings.Index(line, "##") == 0 { // New Month
-			if todoMonth != nil {
-				tl.Months = append(tl.Months, todoMonth)
-			}
+// ParseMarkdownReader parses a todo list, all problems found are returned
+// as *ParseErrors.
+func ParseMarkdownReader(r io.Reader) (*TodoList, error) {
+	tokens, finalNewline, err := tokenize(r)
+	if err != nil {
+		return nil, err
+	}
 
-			re, _ := regexp.Compile("##.*\\s(.*)\\/(.*)")
-			matches := re.FindAllStringSubmatch(line, -1)
-			for _, match := range matches {
-				fmt.Printf("key=%s, value=%s\n", match[1], match[2])
-				curYearInt, _ = strconv.Atoi(match[2])
-				if strings.Index(match[1], "0") == 0 {
-					match[1] = strings.ReplaceAll(match[1], "0", "")
-				}
-				month, _ := strconv.Atoi(match[1])
-				curYear = time.Date(curYearInt, time.Month(month), 1, 1, 1, 0, 0, time.Local)
-				fmt.Println("Setting new year to ", curYearInt, " and month ", month)
-				break
-			}
+	doc, errs := parseDocument(tokens, finalNewline)
+	tl, buildErrs := buildTodoList(doc)
+	if err := parseErrors(append(errs, buildErrs...)); err != nil {
+		return nil, err
+	}
+	return tl, nil
+}
 
-			todoMonth = &TodoMonth{
-				Goals: []*TodoItem{},
-				Items: []*TodoItem{},
-				Date:  curYear,
-			}
-		}
+func buildTodoList(doc *Document) (*TodoList, []*ParseError) {
+	tl := &TodoList{
+		src: newDocumentSource(doc),
+		ids: idSet{},
+	}
+	errs := []*ParseError{}
+	// Tasks of days, and those of them with a number
+	dayItems, numbered := 0, 0
 
-		if strings.Index(line, "- goals") >= 0 { // Add goals to month
-			goalsMode = true
+	for _, section := range doc.Sections {
+		if section.Name != "goals" {
+			errs = append(errs, errorf(section.Pos, "unknown section %q before the first month, expected goals", section.Name))
 			continue
 		}
+		goals, goalErrs := buildGoals(section, time.Time{})
+		tl.Goals = append(tl.Goals, goals...)
+		tl.goalsSrc = section.Src
+		errs = append(errs, goalErrs...)
+	}
 
-		if strings.Index(line, "- todos") >= 0 { // Add todos to month
-			goalsMode = false
-			fmt.Println("Moving to todos")
+	for _, m := range doc.Months {
+		// Broken months are reported by the parser already
+		if m.Month == 0 {
 			continue
 		}
-
-		if strings.Index(line, "- [") == -1 { // New date
-			re, _ := regexp.Compile(".*\\s(.*)\\.(.*)\\:.*")
-			matches := re.FindAllStringSubmatch(line, -1)
-			for _, match := range matches {
-				fmt.Printf("key=%s, value=%s\n", match[1], match[2])
-				if strings.Index(match[1], "0") == 0 {
-					match[1] = strings.ReplaceAll(match[1], "0", "")
+		month := &TodoMonth{
+			Goals: []*TodoItem{},
+			Items: []*TodoItem{},
+			Date:  time.Date(m.Year, m.Month, 1, 1, 1, 0, 0, time.Local),
+			src:   m.Src,
+			ids:   tl.ids,
+		}
+		month.srcDate = month.Date
+
+		for _, section := range m.Sections {
+			switch section.Name {
+			case "goals":
+				goals, goalErrs := buildGoals(section, month.Date)
+				month.Goals = append(month.Goals, goals...)
+				errs = append(errs, goalErrs...)
+				month.goalsSrc = section.Src
+			case "todos":
+				for _, item := range section.Items {
+					errs = append(errs, errorf(item.Pos, "todo %q without day", item.Text))
 				}
-				month, _ := strconv.Atoi(match[2])
-				if strings.Index(match[2], "0") == 0 {
-					match[2] = strings.ReplaceAll(match[2], "0", "")
+				month.todosSrc = section.Src
+				for _, d := range section.Days {
+					if d.Day == 0 {
+						continue
+					}
+					day := time.Date(d.Year, d.Month, d.Day, 0, 0, 0, 0, time.Local)
+					block := newDayBlock(day, d.Src)
+					month.days = append(month.days, block)
+					for _, node := range d.Items {
+						item, itemErrs := buildTodoItem(node, day)
+						errs = append(errs, itemErrs...)
+						if item == nil {
+							continue
+						}
+						// Numbers are written by position in the day
+						item.srcNumber, item.Task = splitNumber(item.Task)
+						item.srcText = numberPrefix(item.srcNumber) + withMetadata(item.Task, item.Metadata)
+						item.srcIndex = block.items
+						item.block = block
+						block.items++
+						month.Items = append(month.Items, item)
+						dayItems++
+						if item.srcNumber > 0 {
+							numbered++
+						}
+					}
 				}
-				day, _ := strconv.Atoi(match[1])
-				curDay = time.Date(2023, time.Month(month), day, 0, 0, 0, 0, time.Local)
-				break
-			}
-		} else {
-			res := strings.Trim(line, "")
-			done := strings.Index(line, "[x]") >= 0
-			inProgress := strings.Index(line, "[0]") >= 0
-			res = strings.ReplaceAll(res, "- [ ] ", "")
-			res = strings.ReplaceAll(res, "- [x] ", "")
-			res = strings.ReplaceAll(res, "- [0] ", "")
-			res = strings.Trim(res, " ")
-			td := &TodoItem{
-				Done:       done,
-				InProgress: inProgress,
-				Task:       res,
-				Day:        curDay,
-			}
-			logrus.Warn(*td)
-			if goalsMode {
-				todoMonth.Goals = append(todoMonth.Goals, td)
-			} else {
-				todoMonth.Items = append(todoMonth.Items, td)
+			default:
+				errs = append(errs, errorf(section.Pos, "unknown section %q, expected goals or todos", section.Name))
 			}
 		}
+
+		tl.Months = append(tl.Months, month)
 	}
 
-	tl.Months = append(tl.Months, todoMonth)
+	tl.walk(func(item *TodoItem) {
+		tl.ids.add(item.ID)
+	})
+	tl.Numbered = numbered > 0 || dayItems == 0
+	return tl, errs
+}
 
-	readFile.Close()
-	return tl, nil
+func buildGoals(section *SectionNode, date time.Time) ([]*TodoItem, []*ParseError) {
+	goals := []*TodoItem{}
+	errs := []*ParseError{}
+	for _, d := range section.Days {
+		errs = append(errs, errorf(d.Pos, "unexpected day in goals"))
+	}
+	for _, node := range section.Items {
+		item, itemErrs := buildTodoItem(node, date)
+		errs = append(errs, itemErrs...)
+		if item != nil {
+			goals = append(goals, item)
+		}
+	}
+	return goals, errs
+}
 
+func buildTodoItem(node *ItemNode, day time.Time) (*TodoItem, []*ParseError) {
+	task, meta := parseMetadata(node.Text)
+	// A text of metadata only is the task
+	if task == "" {
+		task, meta = node.Text, Metadata{}
+	}
+	item := &TodoItem{
+		ID:        node.Attrs[attrID],
+		Task:      task,
+		Day:       day,
+		Metadata:  meta,
+		src:       newItemSource(node),
+		srcMarker: node.Marker,
+		srcText:   withMetadata(task, meta),
+		Notes:     strings.Join(node.Notes, "\n"),
+		attrs:     otherAttrs(node.Attrs, attrID),
+	}
+	status, ok := parseStatus(node.Marker)
+	if !ok {
+		return nil, []*ParseError{errorf(node.MarkerPos, "unknown status [%s], expected [ ], [x], [0], [-], [>] or [!]", node.Marker)}
+	}
+	item.Status = status
+	item.srcNotes = item.Notes
+
+	errs := []*ParseError{}
+	for _, childNode := range node.Children {
+		child, childErrs := buildTodoItem(childNode, day)
+		errs = append(errs, childErrs...)
+		if child != nil {
+			child.parent = item
+			item.Children = append(item.Children, child)
+		}
+	}
+	return item, errs
 }
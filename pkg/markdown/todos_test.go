@@ -0,0 +1,170 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+}
+
+const yearBoundaryTodos = `## 12/2022
+- goals:
+    - [ ] Ship release
+- todos:
+    - 03.12:
+        - [ ] 1) december task
+    - 01.01:
+        - [ ] 1) new year task
+## 01/2023
+- goals:
+- todos:
+    - 31.12:
+        - [x] 1) late entry
+    - 03.01:
+        - [ ] 1) january task
+`
+
+func TestParseAcrossYearBoundary(t *testing.T) {
+	tl, err := ParseMarkdownReader(strings.NewReader(yearBoundaryTodos))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tl.String(); got != yearBoundaryTodos {
+		t.Errorf("written\n%s\nwant\n%s", got, yearBoundaryTodos)
+	}
+
+	tests := []struct {
+		month int
+		task  string
+		day   time.Time
+	}{
+		{0, "december task", date(2022, time.December, 3)},
+		{0, "new year task", date(2023, time.January, 1)},
+		{1, "late entry", date(2022, time.December, 31)},
+		{1, "january task", date(2023, time.January, 3)},
+	}
+	for _, test := range tests {
+		var item *TodoItem
+		for _, i := range tl.Months[test.month].Items {
+			if i.Task == test.task {
+				item = i
+			}
+		}
+		if item == nil {
+			t.Errorf("%q missing in month %d", test.task, test.month)
+			continue
+		}
+		if !DayEqual(item.Day, test.day) || item.Day.Year() != test.day.Year() {
+			t.Errorf("%q is on %s, want %s", test.task, item.Day.Format("2006-01-02"), test.day.Format("2006-01-02"))
+		}
+	}
+}
+
+func TestGetMonthAcrossYears(t *testing.T) {
+	tl, err := ParseMarkdownReader(strings.NewReader("## 12/2022\n- todos:\n## 01/2023\n- todos:\n## 12/2023\n- todos:\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		date time.Time
+		want int
+	}{
+		{date(2022, time.December, 31), 0},
+		{date(2023, time.January, 1), 1},
+		{date(2023, time.December, 3), 2},
+		{date(2024, time.January, 3), -1},
+		{date(2021, time.December, 3), -1},
+	}
+	for _, test := range tests {
+		month := tl.GetMonth(test.date)
+		switch {
+		case test.want < 0 && month != nil:
+			t.Errorf("GetMonth(%s) returned %s, want none", test.date.Format("2006-01-02"), month.Date.Format("01/2006"))
+		case test.want >= 0 && month != tl.Months[test.want]:
+			t.Errorf("GetMonth(%s) returned %v, want month %d", test.date.Format("2006-01-02"), month, test.want)
+		}
+	}
+}
+
+func TestAddMonthAcrossYearBoundary(t *testing.T) {
+	tl, err := ParseMarkdownReader(strings.NewReader(yearBoundaryTodos))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		date    time.Time
+		heading string
+		index   int
+	}{
+		{date(2022, time.November, 3), "## 11/2022", 0},
+		{date(2023, time.February, 3), "## 02/2023", 3},
+	}
+	for _, test := range tests {
+		month := tl.AddMonth(test.date, true)
+		if tl.Months[test.index] != month {
+			t.Errorf("%s added at wrong position", test.heading)
+		}
+		if !strings.Contains(tl.String(), test.heading+"\n") {
+			t.Errorf("%s not written", test.heading)
+		}
+	}
+
+	// Goals are carried over from December into January
+	tl, err = ParseMarkdownReader(strings.NewReader("## 12/2022\n- goals:\n    - [ ] Ship release\n- todos:\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	month := tl.AddMonth(date(2023, time.January, 3), true)
+	if len(tl.Months) != 2 || tl.Months[1] != month || len(month.Goals) != 1 {
+		t.Errorf("January 2023 not added after December 2022 with its goal")
+	}
+}
+
+func TestTimeTrackingAcrossYearBoundary(t *testing.T) {
+	content := `## 12/2022
+- times:
+    - 31.12:
+        - [23:00-23:30] december task
+## 01/2023
+- times:
+    - 01.01:
+        - [00:30-01:00] january task
+`
+	tl, err := ParseTimeTrackingMarkdownReader(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tl.String(); got != content {
+		t.Errorf("written\n%s\nwant\n%s", got, content)
+	}
+
+	tests := []struct {
+		date time.Time
+		task string
+	}{
+		{date(2022, time.December, 31), "december task"},
+		{date(2023, time.January, 1), "january task"},
+	}
+	for _, test := range tests {
+		month := tl.GetMonth(test.date)
+		if month == nil || len(month.Items) != 1 {
+			t.Errorf("no times in month of %s", test.date.Format("2006-01-02"))
+			continue
+		}
+		item := month.Items[0]
+		if item.Task != test.task || !DayEqual(item.Start, test.date) || item.Start.Year() != test.date.Year() {
+			t.Errorf("%q started %s, want %s on %s", item.Task, item.Start, test.task, test.date.Format("2006-01-02"))
+		}
+	}
+
+	month := tl.AddMonth(date(2023, time.February, 1))
+	if len(tl.Months) != 3 || tl.Months[2] != month {
+		t.Errorf("February 2023 not added after January 2023")
+	}
+	if tl.GetMonth(date(2021, time.December, 31)) != nil {
+		t.Errorf("December 2021 found in list of 2022 and 2023")
+	}
+}
@@ -0,0 +1,41 @@
+package timetracking
+
+import (
+	"bytes"
+
+	"github.com/martenwallewein/todo-service/pkg/git"
+	"github.com/martenwallewein/todo-service/pkg/markdown"
+)
+
+// RegisterMerger resolves rebase conflicts of the time tracking list in
+// repo tracking by tracking instead of failing the rebase.
+func RegisterMerger(repo git.Repository) {
+	repo.RegisterMerger(timeTrackingFile, Merge)
+}
+
+// Merge is a git.MergeFunc merging conflicting versions of the time
+// tracking list on the level of single trackings.
+func Merge(base, ours, theirs []byte) ([]byte, error) {
+	baseList, err := markdown.ParseTimeTrackingMarkdownReader(bytes.NewReader(base))
+	if err != nil {
+		return nil, err
+	}
+	ourList, err := markdown.ParseTimeTrackingMarkdownReader(bytes.NewReader(ours))
+	if err != nil {
+		return nil, err
+	}
+	theirList, err := markdown.ParseTimeTrackingMarkdownReader(bytes.NewReader(theirs))
+	if err != nil {
+		return nil, err
+	}
+
+	merged, conflicts := markdown.MergeTimeTrackingLists(baseList, ourList, theirList)
+	if len(conflicts) > 0 {
+		return nil, &markdown.MergeError{
+			File:      timeTrackingFile,
+			Conflicts: conflicts,
+		}
+	}
+
+	return []byte(merged.String()), nil
+}
@@ -0,0 +1,37 @@
+package timetracking
+
+import (
+	"strings"
+	"testing"
+)
+
+const mergeBase = `## 01/2023
+- times:
+    - 03.01:
+        - [09:00-] a <!-- id:aaaaaa -->
+        - [10:00-11:00] b
+`
+
+func TestMergeKeepsBothChanges(t *testing.T) {
+	ours := strings.Replace(mergeBase, "[09:00-] a", "[09:00-12:15] a", 1)
+	theirs := mergeBase + "        - [13:00-] c <!-- id:cccccc -->\n"
+
+	merged, err := Merge([]byte(mergeBase), []byte(ours), []byte(theirs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ours + "        - [13:00-] c <!-- id:cccccc -->\n"
+	if string(merged) != want {
+		t.Errorf("merged\n%s\nwant\n%s", merged, want)
+	}
+}
+
+func TestMergeReportsConflicts(t *testing.T) {
+	ours := strings.Replace(mergeBase, "[09:00-] a", "[09:00-12:15] a", 1)
+	theirs := strings.Replace(mergeBase, "[09:00-] a", "[09:00-09:30] a", 1)
+
+	_, err := Merge([]byte(mergeBase), []byte(ours), []byte(theirs))
+	if err == nil || !strings.Contains(err.Error(), "changed on both sides") {
+		t.Errorf("Merge returned %v, want conflict on a", err)
+	}
+}
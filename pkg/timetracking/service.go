@@ -1,125 +1,138 @@
 package timetracking
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"time"
 
-	"github.com/martenwallewein/todo-service/pkg/git"
 	"github.com/martenwallewein/todo-service/pkg/markdown"
-	"github.com/sirupsen/logrus"
+	"github.com/martenwallewein/todo-service/pkg/workspace"
 )
 
+const timeTrackingFile = "timetracking.md"
+
+var ErrTaskCancelled = errors.New("Cancelled tasks cannot be tracked")
+
 type TimeTrackingService struct {
-	repoPath string
+	ws *workspace.Workspace
 }
 
-func NewTimeTrackingService(repoPath string) *TimeTrackingService {
+func NewTimeTrackingService(ws *workspace.Workspace) *TimeTrackingService {
 	return &TimeTrackingService{
-		repoPath,
+		ws,
 	}
 }
 
-func (ts *TimeTrackingService) PrepareRepo() (*git.GitRepo, error) {
-	logrus.Warn(ts.repoPath)
-	repo, err := git.Load(ts.repoPath)
-	if err != nil {
-		return nil, err
+func (ts *TimeTrackingService) LoadTimeTrackingList(repoPath string) (*markdown.TimeTrackingList, error) {
+	return markdown.ParseTimeTrackingMarkdown(filepath.Join(repoPath, timeTrackingFile))
+}
+
+// Load returns the time tracking list of the unit of work, parsing it on
+// first use. The list is written back once the unit of work completes, a
+// missing list is created then.
+func (ts *TimeTrackingService) Load(uow *workspace.UnitOfWork) (*markdown.TimeTrackingList, error) {
+	if tl, ok := uow.Get(timeTrackingFile).(*markdown.TimeTrackingList); ok {
+		return tl, nil
 	}
 
-	err = repo.FetchAndRebase()
+	tl, err := ts.LoadTimeTrackingList(uow.Path())
+	if errors.Is(err, os.ErrNotExist) {
+		tl, err = &markdown.TimeTrackingList{}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return repo, nil
+	uow.Track(timeTrackingFile, tl)
+	return tl, nil
 }
 
-func (ts *TimeTrackingService) CommitAndPushRepo(repo *git.GitRepo, message string) error {
-	err := repo.CommitAll(message)
-	if err != nil {
-		return err
-	}
-
-	err = repo.Push()
+func (ts *TimeTrackingService) currentMonth(uow *workspace.UnitOfWork) (*markdown.TimeTrackingMonth, error) {
+	tl, err := ts.Load(uow)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	// The first change of a month starts its section
+	return tl.AddMonth(time.Now()), nil
 }
 
-func (ts *TimeTrackingService) LoadTimeTrackingList() (*markdown.TimeTrackingList, error) {
-	return markdown.ParseTimeTrackingMarkdown(filepath.Join(ts.repoPath, "timetracking.md"))
-}
-
-func (ts *TimeTrackingService) SaveTimeTrackingList(tl *markdown.TimeTrackingList) error {
-	return tl.WriteToFile(filepath.Join(ts.repoPath, "timetracking.md"))
-}
-
-func (ts *TimeTrackingService) CompleteTodayTimeTracking(task string) error {
-	repo, err := ts.PrepareRepo()
-	if err != nil {
-		return err
-	}
-	tl, err := ts.LoadTimeTrackingList()
+// CompleteTodayTask stops tracking the todo with todoID, or task if the
+// tracking is not linked to a todo, and returns the stopped item, or nil if
+// the task was not tracked.
+func (ts *TimeTrackingService) CompleteTodayTask(uow *workspace.UnitOfWork, task, todoID string) (*markdown.TimeTrackingItem, error) {
+	month, err := ts.currentMonth(uow)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	month := tl.GetCurrentMonth()
-	month.CompleteTodayTask(task)
-
-	err = ts.SaveTimeTrackingList(tl)
-	if err != nil {
-		return err
-	}
+	return month.CompleteTodayTask(task, todoID), nil
+}
 
-	err = ts.CommitAndPushRepo(repo, fmt.Sprintf("Complete task %s", task))
+// StartTodayTask starts tracking task now, linked to the todo with todoID
+// if not empty, and returns the tracked item.
+func (ts *TimeTrackingService) StartTodayTask(uow *workspace.UnitOfWork, task, todoID string) (*markdown.TimeTrackingItem, error) {
+	month, err := ts.currentMonth(uow)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return month.StartTodayTask(task, todoID), nil
 }
 
-func (ts *TimeTrackingService) StartTodayTimeTracking(task string) error {
-	repo, err := ts.PrepareRepo()
-	if err != nil {
-		return err
-	}
-	tl, err := ts.LoadTimeTrackingList()
-	if err != nil {
-		return err
+// StartTodo starts tracking the todo item now, unless it is tracked
+// already, and returns the tracked item. Cancelled todos are refused with
+// ErrTaskCancelled.
+func (ts *TimeTrackingService) StartTodo(uow *workspace.UnitOfWork, item *markdown.TodoItem) (*markdown.TimeTrackingItem, error) {
+	if item.Status == markdown.StatusCancelled {
+		return nil, fmt.Errorf("%w: %s", ErrTaskCancelled, item.Task)
 	}
 
-	month := tl.GetCurrentMonth()
-	month.StartTodayTask(task)
-
-	err = ts.SaveTimeTrackingList(tl)
+	month, err := ts.currentMonth(uow)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	err = ts.CommitAndPushRepo(repo, fmt.Sprintf("Complete task %s", task))
-	if err != nil {
-		return err
+	if tracked := month.RunningTodayTask(item.Task, item.ID); tracked != nil {
+		return tracked, nil
 	}
-
-	return nil
+	return month.StartTodayTask(item.Task, item.ID), nil
 }
 
-func (ts *TimeTrackingService) GetTodaysTimeTrackings() ([]*markdown.TimeTrackingItem, error) {
+// GetTimeTrackingListAt returns the time tracking list at rev, or as of at
+// if rev is empty, and the revision read.
+func (ts *TimeTrackingService) GetTimeTrackingListAt(ctx context.Context, rev string, at time.Time) (*markdown.TimeTrackingList, string, error) {
+	content, rev, err := ts.ws.ReadAt(ctx, timeTrackingFile, rev, at)
+	if err != nil {
+		return nil, "", err
+	}
 
-	_, err := ts.PrepareRepo()
+	tl, err := markdown.ParseTimeTrackingMarkdownReader(bytes.NewReader(content))
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
+	return tl, rev, nil
+}
 
-	tl, err := ts.LoadTimeTrackingList()
+func (ts *TimeTrackingService) GetTodaysTimeTrackings(ctx context.Context) ([]*markdown.TimeTrackingItem, error) {
+	var items []*markdown.TimeTrackingItem
+	err := ts.ws.Read(func(repoPath string) error {
+		tl, err := ts.LoadTimeTrackingList(repoPath)
+		if err != nil {
+			return err
+		}
+
+		items = []*markdown.TimeTrackingItem{}
+		if month := tl.GetCurrentMonth(); month != nil {
+			items = month.GetTodaysTasks()
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	month := tl.GetCurrentMonth()
-	return month.GetTodaysTasks(), nil
+	return items, nil
 }
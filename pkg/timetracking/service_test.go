@@ -0,0 +1,45 @@
+package timetracking
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/martenwallewein/todo-service/pkg/git"
+	"github.com/martenwallewein/todo-service/pkg/workspace"
+)
+
+func TestStartCreatesMissingList(t *testing.T) {
+	ctx := context.Background()
+	remote := git.NewMemoryRemote()
+	repo, err := git.NewMemoryRepo(t.TempDir(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repo.WorkDir(), "todos.md"), []byte("## 01/2023\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CommitAll(ctx, "Initial commit", nil); err != nil {
+		t.Fatal(err)
+	}
+	ws := workspace.New(repo, workspace.Config{})
+	t.Cleanup(ws.Close)
+	ts := NewTimeTrackingService(ws)
+
+	err = ws.Mutate(ctx, git.NewMessage("Start tracking a"), func(uow *workspace.UnitOfWork) error {
+		_, err := ts.StartTodayTask(uow, "a", "")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(ws.Path(), timeTrackingFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "] a ") {
+		t.Errorf("time tracking list\n%s\nwants a tracked", content)
+	}
+}
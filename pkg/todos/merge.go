@@ -0,0 +1,41 @@
+package todos
+
+import (
+	"bytes"
+
+	"github.com/martenwallewein/todo-service/pkg/git"
+	"github.com/martenwallewein/todo-service/pkg/markdown"
+)
+
+// RegisterMerger resolves rebase conflicts of the todo list in repo item
+// by item instead of failing the rebase.
+func RegisterMerger(repo git.Repository) {
+	repo.RegisterMerger(todoFile, Merge)
+}
+
+// Merge is a git.MergeFunc merging conflicting versions of the todo list
+// on the level of single todo items.
+func Merge(base, ours, theirs []byte) ([]byte, error) {
+	baseList, err := markdown.ParseMarkdownReader(bytes.NewReader(base))
+	if err != nil {
+		return nil, err
+	}
+	ourList, err := markdown.ParseMarkdownReader(bytes.NewReader(ours))
+	if err != nil {
+		return nil, err
+	}
+	theirList, err := markdown.ParseMarkdownReader(bytes.NewReader(theirs))
+	if err != nil {
+		return nil, err
+	}
+
+	merged, conflicts := markdown.MergeTodoLists(baseList, ourList, theirList)
+	if len(conflicts) > 0 {
+		return nil, &markdown.MergeError{
+			File:      todoFile,
+			Conflicts: conflicts,
+		}
+	}
+
+	return []byte(merged.String()), nil
+}
@@ -0,0 +1,46 @@
+package todos
+
+import (
+	"strings"
+	"testing"
+)
+
+const mergeBase = `# Todos
+
+Intro text kept as is.
+
+## 01/2023
+- goals:
+    - [ ] Ship release
+- todos:   
+    - 03.01
+        - [ ] 1) a
+
+        - [ ] 2) b
+    - 04.01:
+        - [ ] 1) c
+`
+
+func TestMergeKeepsUntouchedContent(t *testing.T) {
+	ours := strings.Replace(mergeBase, "[ ] 2) b", "[x] 2) b", 1)
+	theirs := strings.Replace(mergeBase, "        - [ ] 1) c\n", "        - [ ] 1) c\n        - [ ] 2) d\n", 1)
+
+	merged, err := Merge([]byte(mergeBase), []byte(ours), []byte(theirs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(theirs, "[ ] 2) b", "[x] 2) b", 1)
+	if string(merged) != want {
+		t.Errorf("merged\n%s\nwant\n%s", merged, want)
+	}
+}
+
+func TestMergeReportsConflicts(t *testing.T) {
+	ours := strings.Replace(mergeBase, "[ ] 2) b", "[x] 2) b", 1)
+	theirs := strings.Replace(mergeBase, "[ ] 2) b", "[-] 2) b", 1)
+
+	_, err := Merge([]byte(mergeBase), []byte(ours), []byte(theirs))
+	if err == nil || !strings.Contains(err.Error(), "status changed on both sides") {
+		t.Errorf("Merge returned %v, want conflict on b", err)
+	}
+}
@@ -0,0 +1,45 @@
+package todos
+
+import (
+	"fmt"
+
+	"github.com/martenwallewein/todo-service/pkg/git"
+	"github.com/martenwallewein/todo-service/pkg/markdown"
+)
+
+// Trailers recorded in every commit changing a todo, so that the
+// history can be queried by action and task.
+const (
+	TrailerAction = "Todo-Action"
+	TrailerTask   = "Todo-Task"
+	TrailerID     = "Todo-ID"
+)
+
+const (
+	ActionAdd      = "add"
+	ActionComplete = "complete"
+	ActionStart    = "start"
+	ActionStatus   = "status"
+	ActionNotes    = "notes"
+	ActionEdit     = "edit"
+	ActionRemove   = "remove"
+	ActionRollover = "rollover"
+)
+
+// Message returns the commit message for applying action to task.
+func Message(action, task string, subject string, args ...interface{}) *git.Message {
+	return git.NewMessage(fmt.Sprintf(subject, args...)).
+		With(TrailerAction, action).
+		With(TrailerTask, task)
+}
+
+// Resolve records the item a change was applied to in message, once the
+// task addressed by text or ID is found. subject is formatted with the
+// full task.
+func Resolve(message *git.Message, subject string, item *markdown.TodoItem) {
+	message.Subject = fmt.Sprintf(subject, item.Task)
+	message.Set(TrailerTask, item.Task)
+	if item.ID != "" {
+		message.Set(TrailerID, item.ID)
+	}
+}
@@ -1,167 +1,428 @@
 package todos
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/martenwallewein/todo-service/pkg/git"
 	"github.com/martenwallewein/todo-service/pkg/markdown"
+	"github.com/martenwallewein/todo-service/pkg/workspace"
 	"github.com/sirupsen/logrus"
 )
 
+const todoFile = "todos.md"
+
+type Config struct {
+	// CarryOverGoals copies unfinished goals into a newly created month
+	CarryOverGoals bool
+	// AutoCompleteParents completes a task once all its subtasks are done
+	AutoCompleteParents bool
+	// PlainTasks writes the tasks of a day without numbers
+	PlainTasks bool
+	// Rollover selects the unfinished tasks of past days moved to today
+	Rollover markdown.RolloverPolicy
+	// RolloverTrigger runs the rollover on the first request of a day or
+	// on a schedule, see RolloverRequest and RolloverSchedule. Otherwise
+	// it runs only if asked for.
+	RolloverTrigger string
+	// RolloverAt is the time after midnight a scheduled rollover runs at
+	RolloverAt time.Duration
+}
+
+// Triggers of the rollover
+const (
+	RolloverRequest  = "request"
+	RolloverSchedule = "schedule"
+)
+
 type TodoService struct {
-	repoPath string
+	ws     *workspace.Workspace
+	config Config
+
+	// Day of the last rollover run by the service
+	rolloverMu sync.Mutex
+	rolledOver time.Time
 }
 
-func NewTodoService(repoPath string) *TodoService {
+func NewTodoService(ws *workspace.Workspace, config Config) *TodoService {
 	return &TodoService{
-		repoPath,
+		ws:     ws,
+		config: config,
 	}
 }
 
-func (ts *TodoService) PrepareRepo() (*git.GitRepo, error) {
-	logrus.Warn(ts.repoPath)
-	repo, err := git.Load(ts.repoPath)
-	if err != nil {
-		return nil, err
+func (ts *TodoService) LoadTodoList(repoPath string) (*markdown.TodoList, error) {
+	return markdown.ParseMarkdown(filepath.Join(repoPath, todoFile))
+}
+
+// Load returns the todo list of the unit of work, parsing it on first
+// use. The list is written back once the unit of work completes.
+func (ts *TodoService) Load(uow *workspace.UnitOfWork) (*markdown.TodoList, error) {
+	if tl, ok := uow.Get(todoFile).(*markdown.TodoList); ok {
+		return tl, nil
 	}
 
-	err = repo.FetchAndRebase()
+	tl, err := ts.LoadTodoList(uow.Path())
 	if err != nil {
 		return nil, err
 	}
+	tl.Numbered = !ts.config.PlainTasks
 
-	return repo, nil
+	uow.Track(todoFile, tl)
+	return tl, nil
 }
 
-func (ts *TodoService) CommitAndPushRepo(repo *git.GitRepo, message string) error {
-	err := repo.CommitAll(message)
-	if err != nil {
-		return err
-	}
-
-	err = repo.Push()
+func (ts *TodoService) currentMonth(uow *workspace.UnitOfWork) (*markdown.TodoMonth, error) {
+	tl, err := ts.Load(uow)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	// The first change of a month starts its section
+	return tl.AddMonth(time.Now(), ts.config.CarryOverGoals), nil
 }
 
-func (ts *TodoService) LoadTodoList() (*markdown.TodoList, error) {
-	return markdown.ParseMarkdown(filepath.Join(ts.repoPath, "todos.md"))
+// readCurrentMonth runs fn on the current month of a consistent snapshot
+// of the todo list in the working tree.
+func (ts *TodoService) readCurrentMonth(ctx context.Context, fn func(month *markdown.TodoMonth) error) error {
+	return ts.ws.Read(func(repoPath string) error {
+		tl, err := ts.LoadTodoList(repoPath)
+		if err != nil {
+			return err
+		}
+
+		month := tl.GetCurrentMonth()
+		if month == nil {
+			month = &markdown.TodoMonth{}
+		}
+		return fn(month)
+	})
 }
 
-func (ts *TodoService) SaveTodoList(tl *markdown.TodoList) error {
-	return tl.WriteToFile(filepath.Join(ts.repoPath, "todos.md"))
+// Ref addresses a task by ID, or by text among the tasks of today.
+type Ref struct {
+	ID   string
+	Task string
 }
 
-func (ts *TodoService) AddTodayTodo(task string) error {
+func (r Ref) String() string {
+	if r.ID != "" {
+		return r.ID
+	}
+	return r.Task
+}
 
-	repo, err := ts.PrepareRepo()
+// AddTodayTask adds task to today's tasks, meta is added to the metadata
+// written inline in task.
+func (ts *TodoService) AddTodayTask(uow *workspace.UnitOfWork, task string, meta markdown.Metadata, notes string) (*markdown.TodoItem, error) {
+	month, err := ts.currentMonth(uow)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	tl, err := ts.LoadTodoList()
-	if err != nil {
-		return err
+
+	item := month.AddTodayTask(task, markdown.StatusOpen)
+	item.Metadata.Update(meta)
+	if err := item.SetNotes(notes); err != nil {
+		return nil, err
+	}
+	if err := ts.checkGoal(uow, item); err != nil {
+		return nil, err
 	}
+	return item, nil
+}
 
-	month := tl.GetCurrentMonth()
-	month.AddTodayTask(task, false, false)
+// CompleteTask completes the task of ref. A task of today addressed by
+// text is added as completed if it does not exist.
+func (ts *TodoService) CompleteTask(uow *workspace.UnitOfWork, ref Ref) (*markdown.TodoItem, error) {
+	if ref.ID != "" {
+		item, err := ts.getTask(uow, ref.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := ts.complete(item); err != nil {
+			return nil, err
+		}
+		return item, nil
+	}
 
-	err = ts.SaveTodoList(tl)
+	month, err := ts.currentMonth(uow)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return month.CompleteTodayTask(ref.Task)
+}
 
-	err = ts.CommitAndPushRepo(repo, fmt.Sprintf("Add task %s to todos", task))
-	if err != nil {
+func (ts *TodoService) complete(item *markdown.TodoItem) error {
+	return ts.setStatus(item, markdown.StatusDone)
+}
+
+func (ts *TodoService) setStatus(item *markdown.TodoItem, status markdown.Status) error {
+	if err := item.SetStatus(status); err != nil {
 		return err
 	}
-
+	// Cancelled subtasks can leave only done ones behind
+	if ts.config.AutoCompleteParents && status.Closed() {
+		item.CompleteParents()
+	}
 	return nil
 }
 
-func (ts *TodoService) CompleteTodayTodo(task string) error {
-	repo, err := ts.PrepareRepo()
+// AddSubtask adds task as subtask of the task with parentID.
+func (ts *TodoService) AddSubtask(uow *workspace.UnitOfWork, parentID, task string, meta markdown.Metadata, notes string) (*markdown.TodoItem, error) {
+	tl, err := ts.Load(uow)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	tl, err := ts.LoadTodoList()
+	parent, err := tl.GetTask(parentID)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	item := tl.AddSubtask(parent, task)
+	item.Metadata.Update(meta)
+	if err := item.SetNotes(notes); err != nil {
+		return nil, err
+	}
+	if err := ts.checkGoal(uow, item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
 
-	month := tl.GetCurrentMonth()
-	month.CompleteTodayTask(task)
-
-	err = ts.SaveTodoList(tl)
+// checkGoal checks that the goal item links to exists.
+func (ts *TodoService) checkGoal(uow *workspace.UnitOfWork, item *markdown.TodoItem) error {
+	if item.Goal == "" {
+		return nil
+	}
+	tl, err := ts.Load(uow)
 	if err != nil {
 		return err
 	}
+	_, err = tl.FindGoal(tl.GetMonth(item.Day), item.Goal)
+	return err
+}
 
-	err = ts.CommitAndPushRepo(repo, fmt.Sprintf("Complete task %s", task))
+// SetNotes replaces the notes of the task with id.
+func (ts *TodoService) SetNotes(uow *workspace.UnitOfWork, id, notes string) (*markdown.TodoItem, error) {
+	item, err := ts.getTask(uow, id)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	return nil
+	if err := item.SetNotes(notes); err != nil {
+		return nil, err
+	}
+	return item, nil
 }
 
-func (ts *TodoService) GetFullTask(task string) (*markdown.TodoItem, error) {
-	_, err := ts.PrepareRepo()
+// CompleteSubtask completes the subtask of ref of the task with parentID.
+func (ts *TodoService) CompleteSubtask(uow *workspace.UnitOfWork, parentID string, ref Ref) (*markdown.TodoItem, error) {
+	parent, err := ts.getTask(uow, parentID)
 	if err != nil {
 		return nil, err
 	}
-	tl, err := ts.LoadTodoList()
+
+	var item *markdown.TodoItem
+	if ref.ID != "" {
+		item, err = ts.getTask(uow, ref.ID)
+		if err == nil && item.Parent() != parent {
+			err = fmt.Errorf("%w: %s in %s", markdown.ErrTaskNotFound, ref.ID, parent.Task)
+		}
+	} else {
+		item, err = parent.FindSubtask(ref.Task)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	month := tl.GetCurrentMonth()
-	item := month.GetFullTask(task)
+	if err := ts.complete(item); err != nil {
+		return nil, err
+	}
 	return item, nil
 }
 
-func (ts *TodoService) StartTodayTodo(task string) error {
-	repo, err := ts.PrepareRepo()
+// StartTask marks the task of ref as in progress. A task of today
+// addressed by text is added as in progress if it does not exist.
+func (ts *TodoService) StartTask(uow *workspace.UnitOfWork, ref Ref) (*markdown.TodoItem, error) {
+	if ref.ID != "" {
+		item, err := ts.getTask(uow, ref.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := item.Start(); err != nil {
+			return nil, err
+		}
+		return item, nil
+	}
+
+	month, err := ts.currentMonth(uow)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return month.StartTodayTask(ref.Task)
+}
+
+// SetTaskStatus changes the status of the task of ref, see
+// markdown.TodoItem.SetStatus. Unlike completing, a task of today addressed
+// by text has to exist.
+func (ts *TodoService) SetTaskStatus(uow *workspace.UnitOfWork, ref Ref, status markdown.Status) (*markdown.TodoItem, error) {
+	if ref.ID != "" {
+		item, err := ts.getTask(uow, ref.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := ts.setStatus(item, status); err != nil {
+			return nil, err
+		}
+		return item, nil
 	}
-	tl, err := ts.LoadTodoList()
+
+	month, err := ts.currentMonth(uow)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return month.SetTodayTaskStatus(ref.Task, status)
+}
 
-	month := tl.GetCurrentMonth()
-	month.StartTodayTask(task)
+// Rollover moves the unfinished tasks of past days to today, see
+// markdown.TodoList.Rollover.
+func (ts *TodoService) Rollover(uow *workspace.UnitOfWork) ([]*markdown.TodoItem, error) {
+	tl, err := ts.Load(uow)
+	if err != nil {
+		return nil, err
+	}
+	return tl.Rollover(time.Now(), ts.config.Rollover, ts.config.CarryOverGoals), nil
+}
 
-	err = ts.SaveTodoList(tl)
+func (ts *TodoService) getTask(uow *workspace.UnitOfWork, id string) (*markdown.TodoItem, error) {
+	tl, err := ts.Load(uow)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return tl.GetTask(id)
+}
 
-	err = ts.CommitAndPushRepo(repo, fmt.Sprintf("Complete task %s", task))
+func (ts *TodoService) AddTodayTodo(ctx context.Context, task string, meta markdown.Metadata, notes string) (*markdown.TodoItem, error) {
+	var item *markdown.TodoItem
+	message := Message(ActionAdd, task, "Add task %s to todos", task)
+	err := ts.ws.Mutate(ctx, message, func(uow *workspace.UnitOfWork) error {
+		var err error
+		item, err = ts.AddTodayTask(uow, task, meta, notes)
+		if err != nil {
+			return err
+		}
+		Resolve(message, "Add task %s to todos", item)
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return item, nil
+}
 
-	return nil
+// RolloverTodos runs Rollover in a commit of its own and returns the moved
+// tasks.
+func (ts *TodoService) RolloverTodos(ctx context.Context) ([]*markdown.TodoItem, error) {
+	ts.rolloverMu.Lock()
+	defer ts.rolloverMu.Unlock()
+	return ts.rollover(ctx)
 }
 
-func (ts *TodoService) GetTodaysTodos() ([]*markdown.TodoItem, error) {
+// RolloverOnce runs RolloverTodos unless the service rolled over today
+// already.
+func (ts *TodoService) RolloverOnce(ctx context.Context) error {
+	ts.rolloverMu.Lock()
+	defer ts.rolloverMu.Unlock()
+	if markdown.DayEqual(ts.rolledOver, time.Now()) {
+		return nil
+	}
+	_, err := ts.rollover(ctx)
+	return err
+}
 
-	_, err := ts.PrepareRepo()
+func (ts *TodoService) rollover(ctx context.Context) ([]*markdown.TodoItem, error) {
+	var items []*markdown.TodoItem
+	today := time.Now().Format("02.01")
+	message := git.NewMessage(fmt.Sprintf("Roll over tasks to %s", today)).
+		With(TrailerAction, ActionRollover)
+	err := ts.ws.Mutate(ctx, message, func(uow *workspace.UnitOfWork) error {
+		var err error
+		items, err = ts.Rollover(uow)
+		if err != nil {
+			return err
+		}
+		message.Subject = fmt.Sprintf("Roll over %d tasks to %s", len(items), today)
+		for _, item := range items {
+			message.With(TrailerTask, item.Task).With(TrailerID, item.ID)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	ts.rolledOver = time.Now()
+	return items, nil
+}
 
-	tl, err := ts.LoadTodoList()
+// ScheduleRollover runs RolloverOnce every day at RolloverAt.
+func (ts *TodoService) ScheduleRollover() {
+	now := time.Now()
+	next := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).Add(ts.config.RolloverAt)
+	if !next.After(now) {
+		next = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.Local).Add(ts.config.RolloverAt)
+	}
+	time.AfterFunc(next.Sub(now), func() {
+		if err := ts.RolloverOnce(context.Background()); err != nil {
+			logrus.Errorf("Scheduled rollover failed: %v", err)
+		}
+		ts.ScheduleRollover()
+	})
+}
+
+// GetTodoListAt returns the todo list at rev, or as of at if rev is empty,
+// and the revision read.
+func (ts *TodoService) GetTodoListAt(ctx context.Context, rev string, at time.Time) (*markdown.TodoList, string, error) {
+	content, rev, err := ts.ws.ReadAt(ctx, todoFile, rev, at)
+	if err != nil {
+		return nil, "", err
+	}
+
+	tl, err := markdown.ParseMarkdownReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, "", err
+	}
+	return tl, rev, nil
+}
+
+// GetTodaysTodos returns the tasks of today matching filter.
+func (ts *TodoService) GetTodaysTodos(ctx context.Context, filter markdown.TaskFilter) ([]*markdown.TodoItem, error) {
+	var items []*markdown.TodoItem
+	err := ts.readCurrentMonth(ctx, func(month *markdown.TodoMonth) error {
+		items = markdown.FilterTasks(month.GetTodaysTasks(), filter)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return items, nil
+}
 
-	month := tl.GetCurrentMonth()
-	return month.GetTodaysTasks(), nil
+// FindTodos returns all tasks of the todo list matching filter, goals and
+// subtasks included.
+func (ts *TodoService) FindTodos(ctx context.Context, filter markdown.TaskFilter) ([]*markdown.TodoItem, error) {
+	var items []*markdown.TodoItem
+	err := ts.ws.Read(func(repoPath string) error {
+		tl, err := ts.LoadTodoList(repoPath)
+		if err != nil {
+			return err
+		}
+		items = tl.FilterTasks(filter)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
 }
@@ -0,0 +1,227 @@
+package todos
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/martenwallewein/todo-service/pkg/git"
+	"github.com/martenwallewein/todo-service/pkg/markdown"
+	"github.com/martenwallewein/todo-service/pkg/workspace"
+)
+
+const serviceTodos = `## 01/2023
+- goals:
+- todos:
+    - 03.01:
+        - [ ] 1) a <!-- id:aaaaaa -->
+        - [ ] 2) b <!-- id:bbbbbb -->
+`
+
+// newRemote returns a remote with a first commit of todos.
+func newRemote(t *testing.T, todos string) *git.MemoryRemote {
+	t.Helper()
+	remote := git.NewMemoryRemote()
+	clone := newClone(t, remote)
+	writeTodos(t, clone, todos)
+	ctx := context.Background()
+	if err := clone.CommitAll(ctx, "Initial commit", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := clone.Push(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return remote
+}
+
+func newClone(t *testing.T, remote *git.MemoryRemote) *git.MemoryRepo {
+	t.Helper()
+	repo, err := git.NewMemoryRepo(t.TempDir(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// newService returns a todo service on a workspace cloned from remote.
+func newService(t *testing.T, remote *git.MemoryRemote, config workspace.Config) *TodoService {
+	t.Helper()
+	repo := newClone(t, remote)
+	RegisterMerger(repo)
+	ws := workspace.New(repo, config)
+	t.Cleanup(ws.Close)
+	return NewTodoService(ws, Config{})
+}
+
+// push commits todos in another clone of remote and pushes them.
+func push(t *testing.T, remote *git.MemoryRemote, todos string) {
+	t.Helper()
+	other := newClone(t, remote)
+	writeTodos(t, other, todos)
+	ctx := context.Background()
+	if err := other.CommitAll(ctx, "Change todos", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Push(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTodos(t *testing.T, repo git.Repository, todos string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(repo.WorkDir(), todoFile), []byte(todos), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTodos(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(path, todoFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func complete(t *testing.T, ts *TodoService, id string) error {
+	t.Helper()
+	return ts.ws.Mutate(context.Background(), Message(ActionComplete, id, "Complete task %s", id), func(uow *workspace.UnitOfWork) error {
+		_, err := ts.CompleteTask(uow, Ref{ID: id})
+		return err
+	})
+}
+
+func TestRebaseMergesConcurrentChanges(t *testing.T) {
+	remote := newRemote(t, serviceTodos)
+	// The local commit is rebased onto the other change when flushing
+	ts := newService(t, remote, workspace.Config{WriteBehind: time.Hour})
+	if err := complete(t, ts, "aaaaaa"); err != nil {
+		t.Fatal(err)
+	}
+	push(t, remote, strings.Replace(serviceTodos, "[ ] 2) b", "[x] 2) b", 1))
+
+	if err := ts.ws.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(strings.Replace(serviceTodos, "[ ] 2) b", "[x] 2) b", 1), "[ ] 1) a", "[x] 1) a", 1)
+	if got := readTodos(t, newClone(t, remote).WorkDir()); got != want {
+		t.Errorf("remote has\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRebaseReportsConflicts(t *testing.T) {
+	remote := newRemote(t, serviceTodos)
+	ts := newService(t, remote, workspace.Config{WriteBehind: time.Hour})
+	if err := complete(t, ts, "aaaaaa"); err != nil {
+		t.Fatal(err)
+	}
+	push(t, remote, strings.Replace(serviceTodos, "[ ] 1) a", "[-] 1) a", 1))
+
+	var mergeErr *markdown.MergeError
+	if err := ts.ws.Flush(context.Background()); !errors.As(err, &mergeErr) || len(mergeErr.Conflicts) != 1 {
+		t.Fatalf("Flush returned %v, want a conflict on a", err)
+	}
+	if ts.ws.PendingChanges() != 1 {
+		t.Errorf("conflicting change not kept")
+	}
+	if got := readTodos(t, newClone(t, remote).WorkDir()); !strings.Contains(got, "[-] 1) a") {
+		t.Errorf("remote changed by conflicting flush\n%s", got)
+	}
+}
+
+func TestUndoAndRedo(t *testing.T) {
+	remote := newRemote(t, serviceTodos)
+	ts := newService(t, remote, workspace.Config{})
+	ctx := context.Background()
+
+	if err := complete(t, ts, "aaaaaa"); err != nil {
+		t.Fatal(err)
+	}
+	completed := readTodos(t, ts.ws.Path())
+
+	if _, err := ts.Undo(ctx, false, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTodos(t, ts.ws.Path()); got != serviceTodos {
+		t.Errorf("after undo\n%s\nwant\n%s", got, serviceTodos)
+	}
+
+	if _, err := ts.Redo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTodos(t, ts.ws.Path()); got != completed {
+		t.Errorf("after redo\n%s\nwant\n%s", got, completed)
+	}
+	if _, err := ts.Redo(ctx); !errors.Is(err, ErrNothingToRedo) {
+		t.Errorf("second redo returned %v, want ErrNothingToRedo", err)
+	}
+
+	// Undo skips the redo and reverts the completion again, the initial
+	// commit was not made by the service
+	if _, err := ts.Undo(ctx, false, 1); err != nil {
+		t.Fatal(err)
+	}
+	var foreignErr *ForeignCommitError
+	if _, err := ts.Undo(ctx, false, 1); !errors.As(err, &foreignErr) {
+		t.Errorf("undo of the initial commit returned %v, want *ForeignCommitError", err)
+	}
+	if got := readTodos(t, newClone(t, remote).WorkDir()); got != serviceTodos {
+		t.Errorf("remote has\n%s\nwant\n%s", got, serviceTodos)
+	}
+}
+
+func TestUndoSeveralChangesInOneCommit(t *testing.T) {
+	remote := newRemote(t, serviceTodos)
+	ts := newService(t, remote, workspace.Config{})
+	ctx := context.Background()
+
+	for _, id := range []string{"aaaaaa", "bbbbbb"} {
+		if err := complete(t, ts, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only two changes were made by the service, nothing is undone
+	if _, err := ts.Undo(ctx, false, 3); err == nil {
+		t.Fatal("undo of 3 changes succeeded, want an error")
+	}
+	before, err := newClone(t, remote).Log(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != 3 {
+		t.Fatalf("remote has %d commits after failed undo, want 3", len(before))
+	}
+
+	reverted, err := ts.Undo(ctx, false, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != 2 || reverted[0] != before[0].Hash || reverted[1] != before[1].Hash {
+		t.Errorf("reverted %v, want the two completions", reverted)
+	}
+	clone := newClone(t, remote)
+	after, err := clone.Log(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before)+1 {
+		t.Errorf("remote has %d new commits, want 1", len(after)-len(before))
+	}
+	if got := readTodos(t, clone.WorkDir()); got != serviceTodos {
+		t.Errorf("remote has\n%s\nwant\n%s", got, serviceTodos)
+	}
+
+	// A single redo restores both completions
+	if _, err := ts.Redo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(strings.Replace(serviceTodos, "[ ] 1) a", "[x] 1) a", 1), "[ ] 2) b", "[x] 2) b", 1)
+	if got := readTodos(t, ts.ws.Path()); got != want {
+		t.Errorf("after redo\n%s\nwant\n%s", got, want)
+	}
+}
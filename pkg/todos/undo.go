@@ -0,0 +1,203 @@
+package todos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/martenwallewein/todo-service/pkg/git"
+)
+
+// TrailerRevert records the commit reverted by an undo or redo.
+const TrailerRevert = "Todo-Revert"
+
+const (
+	ActionUndo = "undo"
+	ActionRedo = "redo"
+)
+
+var (
+	ErrNothingToUndo = errors.New("Nothing to undo")
+	ErrNothingToRedo = errors.New("Nothing to redo")
+)
+
+// ForeignCommitError is returned if the commit to undo was not made by
+// this service.
+type ForeignCommitError struct {
+	Commit *git.Commit
+}
+
+func (e *ForeignCommitError) Error() string {
+	return fmt.Sprintf("Commit %s %q by %s was not made by this service", e.Commit.Hash, git.ParseMessage(e.Commit.Message).Subject, e.Commit.Author)
+}
+
+// historyEntry is a commit of the history with its parsed message.
+type historyEntry struct {
+	commit  *git.Commit
+	message *git.Message
+}
+
+func (e historyEntry) action() string {
+	actions := e.message.Get(TrailerAction)
+	if len(actions) == 0 {
+		return ""
+	}
+	return actions[0]
+}
+
+// activeHistory returns commits newest first, leaving out commits
+// reverted by a later undo or redo that is itself still in effect.
+func activeHistory(commits []*git.Commit) []historyEntry {
+	reverted := map[string]bool{}
+	entries := []historyEntry{}
+	for _, c := range commits {
+		if reverted[c.Hash] {
+			continue
+		}
+		message := git.ParseMessage(c.Message)
+		for _, hash := range message.Get(TrailerRevert) {
+			reverted[hash] = true
+		}
+		entries = append(entries, historyEntry{c, message})
+	}
+	return entries
+}
+
+// undoTarget returns the most recent change of entries. Commits not made
+// by this service are only returned if force is set.
+func undoTarget(entries []historyEntry, force bool) (*historyEntry, error) {
+	for i, e := range entries {
+		switch e.action() {
+		case ActionUndo:
+			// Undone changes are redone, not undone again
+			continue
+		case "":
+			if !force {
+				return nil, &ForeignCommitError{e.commit}
+			}
+			// The initial commit can not be reverted
+			if i == len(entries)-1 {
+				return nil, ErrNothingToUndo
+			}
+		}
+		return &entries[i], nil
+	}
+	return nil, ErrNothingToUndo
+}
+
+// undoTargets returns the count most recent changes still in effect, as
+// undone one after another. It fails unless all of them can be undone.
+func undoTargets(ctx context.Context, repo git.Repository, force bool, count int) ([]*historyEntry, error) {
+	commits, err := repo.Log(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The undo being built reverts the targets found so far
+	undo := git.NewMessage("Undo").With(TrailerAction, ActionUndo)
+	pending := &git.Commit{}
+	targets := []*historyEntry{}
+	for len(targets) < count {
+		pending.Message = undo.String()
+		target, err := undoTarget(activeHistory(append([]*git.Commit{pending}, commits...)), force)
+		if errors.Is(err, ErrNothingToUndo) && len(targets) > 0 {
+			return nil, fmt.Errorf("%w, only %d changes can be undone", err, len(targets))
+		}
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+		undo.With(TrailerRevert, target.commit.Hash)
+	}
+	return targets, nil
+}
+
+// redoTarget returns the most recent undo still in effect, as long as no
+// other change was made after it.
+func redoTarget(ctx context.Context, repo git.Repository) (*historyEntry, error) {
+	commits, err := repo.Log(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries := activeHistory(commits)
+
+	for i, e := range entries {
+		switch e.action() {
+		case ActionRedo:
+			continue
+		case ActionUndo:
+			return &entries[i], nil
+		}
+		break
+	}
+	return nil, ErrNothingToRedo
+}
+
+// revertMessage returns the message of a commit reverting targets, a
+// single target is named in the subject.
+func revertMessage(verb, action string, targets []*historyEntry) *git.Message {
+	message := git.NewMessage(fmt.Sprintf("%s %s", verb, targets[0].message.Subject))
+	if len(targets) > 1 {
+		message.Subject = fmt.Sprintf("%s %d changes", verb, len(targets))
+		lines := make([]string, 0, len(targets))
+		for _, target := range targets {
+			lines = append(lines, fmt.Sprintf("- %s", target.message.Subject))
+		}
+		message.Body = strings.Join(lines, "\n")
+	}
+	message.With(TrailerAction, action)
+	for _, target := range targets {
+		message.With(TrailerRevert, target.commit.Hash)
+	}
+	for _, target := range targets {
+		for _, key := range []string{TrailerTask, TrailerID} {
+			for _, value := range target.message.Get(key) {
+				message.With(key, value)
+			}
+		}
+	}
+	return message
+}
+
+// Undo reverts the count most recent changes made by this service, or by
+// anyone if force is set, in a single commit. It returns the hashes of
+// the reverted commits, newest first.
+func (ts *TodoService) Undo(ctx context.Context, force bool, count int) ([]string, error) {
+	var reverted []string
+	err := ts.ws.Revert(ctx, func(ctx context.Context, repo git.Repository) ([]string, *git.Message, error) {
+		targets, err := undoTargets(ctx, repo, force, count)
+		if err != nil {
+			return nil, nil, err
+		}
+		reverted = []string{}
+		for _, target := range targets {
+			reverted = append(reverted, target.commit.Hash)
+			target.message.Subject = strings.TrimPrefix(target.message.Subject, "Redo ")
+		}
+		return reverted, revertMessage("Undo", ActionUndo, targets), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reverted, nil
+}
+
+// Redo reverts the most recent undo, if no other change was made since.
+// It returns the hash of the reverted undo.
+func (ts *TodoService) Redo(ctx context.Context) (string, error) {
+	var reverted string
+	err := ts.ws.Revert(ctx, func(ctx context.Context, repo git.Repository) ([]string, *git.Message, error) {
+		target, err := redoTarget(ctx, repo)
+		if err != nil {
+			return nil, nil, err
+		}
+		reverted = target.commit.Hash
+		target.message.Subject = strings.TrimPrefix(target.message.Subject, "Undo ")
+		return []string{reverted}, revertMessage("Redo", ActionRedo, []*historyEntry{target}), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return reverted, nil
+}
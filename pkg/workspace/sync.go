@@ -0,0 +1,172 @@
+package workspace
+
+import (
+	"context"
+	"time"
+
+	"github.com/martenwallewein/todo-service/pkg/git"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	minRetryBackoff = 5 * time.Second
+	maxRetryBackoff = 10 * time.Minute
+)
+
+// SyncState describes how the local clone relates to the remote.
+type SyncState struct {
+	Ahead     int       `json:"ahead"`
+	Behind    int       `json:"behind"`
+	Pending   int       `json:"pending"`
+	LastSync  time.Time `json:"lastSync"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// fetchAndRebase brings the local clone up to date. An unreachable remote
+// is not an error, the workspace keeps working on the local clone.
+func (ws *Workspace) fetchAndRebase(ctx context.Context) error {
+	err := ws.repo.Fetch(ctx)
+	if err != nil {
+		logrus.Warn("Working offline: ", err)
+		ws.syncFailed(err)
+		return nil
+	}
+
+	err = ws.repo.Rebase(ctx)
+	if err != nil {
+		ws.recordError(err)
+		return err
+	}
+
+	ws.synced()
+	return nil
+}
+
+// push pushes local commits, failed pushes are retried in background.
+func (ws *Workspace) push(ctx context.Context) {
+	err := ws.repo.Push(ctx)
+	if err != nil {
+		logrus.Warn("Push failed, retrying in background: ", err)
+		ws.syncFailed(err)
+		return
+	}
+
+	ws.pushed()
+}
+
+// retry pushes all local commits, or flushes them in write-behind mode.
+func (ws *Workspace) retry(ctx context.Context) error {
+	if ws.writeBehind() && ws.PendingChanges() > 0 {
+		return ws.flush(ctx)
+	}
+
+	ahead, _, err := ws.repo.AheadBehind(ctx)
+	if err != nil {
+		return err
+	}
+	if ahead == 0 {
+		return nil
+	}
+
+	err = ws.fetchAndRebase(ctx)
+	if err != nil {
+		return err
+	}
+
+	ws.push(ctx)
+	return nil
+}
+
+func (ws *Workspace) synced() {
+	ws.syncMu.Lock()
+	defer ws.syncMu.Unlock()
+	ws.lastSync = time.Now()
+	ws.lastErr = nil
+}
+
+// pushed records a successful push, nothing is left to retry.
+func (ws *Workspace) pushed() {
+	ws.synced()
+
+	ws.syncMu.Lock()
+	defer ws.syncMu.Unlock()
+	ws.backoff = 0
+	if ws.retryTimer != nil {
+		ws.retryTimer.Stop()
+		ws.retryTimer = nil
+	}
+}
+
+// recordError keeps err to be reported by SyncState.
+func (ws *Workspace) recordError(err error) {
+	ws.syncMu.Lock()
+	defer ws.syncMu.Unlock()
+	ws.lastErr = err
+}
+
+// syncFailed records err of an unreachable remote and schedules a retry.
+func (ws *Workspace) syncFailed(err error) {
+	ws.recordError(err)
+	ws.scheduleRetry()
+}
+
+// scheduleRetry submits a retry job after an exponentially growing delay,
+// unless one is already scheduled.
+func (ws *Workspace) scheduleRetry() {
+	ws.syncMu.Lock()
+	defer ws.syncMu.Unlock()
+	if ws.retryTimer != nil {
+		return
+	}
+
+	if ws.backoff == 0 {
+		ws.backoff = minRetryBackoff
+	} else if ws.backoff < maxRetryBackoff {
+		ws.backoff *= 2
+		if ws.backoff > maxRetryBackoff {
+			ws.backoff = maxRetryBackoff
+		}
+	}
+
+	logrus.Infof("Retrying sync with remote in %s", ws.backoff)
+	ws.retryTimer = time.AfterFunc(ws.backoff, func() {
+		ws.syncMu.Lock()
+		ws.retryTimer = nil
+		ws.syncMu.Unlock()
+
+		err := ws.submit(&job{
+			ctx:     context.Background(),
+			message: git.NewMessage("Retry"),
+			task:    ws.retry,
+			result:  make(chan error, 1),
+		})
+		if err != nil {
+			logrus.Warn(err)
+		}
+	})
+}
+
+// SyncState returns the current state of the synchronization with the
+// remote.
+func (ws *Workspace) SyncState(ctx context.Context) (*SyncState, error) {
+	state := &SyncState{
+		Pending: ws.PendingChanges(),
+	}
+
+	err := ws.Read(func(path string) error {
+		var err error
+		state.Ahead, state.Behind, err = ws.repo.AheadBehind(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ws.syncMu.Lock()
+	defer ws.syncMu.Unlock()
+	state.LastSync = ws.lastSync
+	if ws.lastErr != nil {
+		state.LastError = ws.lastErr.Error()
+	}
+	return state, nil
+}
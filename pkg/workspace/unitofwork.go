@@ -0,0 +1,57 @@
+package workspace
+
+import (
+	"path/filepath"
+)
+
+// Document is a file of the repository that was loaded into memory and
+// can be written back.
+type Document interface {
+	WriteToFile(file string) error
+}
+
+// UnitOfWork tracks all documents loaded during a single job. Once the
+// job succeeds, every tracked document is written back and the changes
+// end up in one commit. If anything fails, the working tree is reset.
+type UnitOfWork struct {
+	path  string
+	docs  map[string]Document
+	names []string
+}
+
+func newUnitOfWork(path string) *UnitOfWork {
+	return &UnitOfWork{
+		path: path,
+		docs: map[string]Document{},
+	}
+}
+
+// Path returns the root of the working tree.
+func (uow *UnitOfWork) Path() string {
+	return uow.path
+}
+
+// Get returns the document loaded for name, or nil if the job did not
+// load it yet.
+func (uow *UnitOfWork) Get(name string) Document {
+	return uow.docs[name]
+}
+
+// Track registers doc to be written to name, relative to the root of the
+// working tree, when the job completes.
+func (uow *UnitOfWork) Track(name string, doc Document) {
+	if _, ok := uow.docs[name]; !ok {
+		uow.names = append(uow.names, name)
+	}
+	uow.docs[name] = doc
+}
+
+func (uow *UnitOfWork) save() error {
+	for _, name := range uow.names {
+		err := uow.docs[name].WriteToFile(filepath.Join(uow.path, name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,487 @@
+package workspace
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/martenwallewein/todo-service/pkg/auth"
+	"github.com/martenwallewein/todo-service/pkg/git"
+	"github.com/sirupsen/logrus"
+)
+
+// Mutation loads documents through the unit of work and changes them.
+// It is always executed on the writer goroutine of the workspace.
+type Mutation func(uow *UnitOfWork) error
+
+type job struct {
+	ctx     context.Context
+	message *git.Message
+	fn      Mutation
+	// Internal jobs like flushing and retrying run task instead of fn
+	task   func(ctx context.Context) error
+	result chan error
+}
+
+type Config struct {
+	// WriteBehind is the debounce window after which locally committed
+	// changes are squashed and pushed. Zero pushes every change directly.
+	WriteBehind time.Duration
+	// Attribution defines how the caller of a mutation is recorded,
+	// AttributeAuthor if empty
+	Attribution string
+	// SyncInterval is the period changes of others are fetched and
+	// rebased onto in background. Reads are served from the working tree
+	// and see them once synced. Zero syncs only before mutations.
+	SyncInterval time.Duration
+}
+
+const (
+	// AttributeAuthor commits as the caller
+	AttributeAuthor = "author"
+	// AttributeTrailer commits with the configured identity and credits
+	// the caller with a Co-authored-by trailer
+	AttributeTrailer = "trailer"
+)
+
+// change is a local commit not pushed yet.
+type change struct {
+	message *git.Message
+	author  *git.Signature
+}
+
+// Workspace owns a cloned repository and serializes every change to it.
+// All mutations are submitted as jobs and executed one after another by
+// a single writer goroutine, reads are served while no job is running.
+type Workspace struct {
+	repo   git.Repository
+	config Config
+	jobs   chan *job
+	lock   sync.RWMutex
+	closed chan struct{}
+	once   sync.Once
+
+	// Messages of local commits not pushed yet in write-behind mode
+	pending    []change
+	pendingMu  sync.Mutex
+	flushTimer *time.Timer
+
+	// State of the synchronization with the remote
+	syncMu     sync.Mutex
+	lastSync   time.Time
+	lastErr    error
+	retryTimer *time.Timer
+	backoff    time.Duration
+}
+
+func New(repo git.Repository, config Config) *Workspace {
+	ws := &Workspace{
+		repo:   repo,
+		config: config,
+		jobs:   make(chan *job),
+		closed: make(chan struct{}),
+	}
+
+	// Commits left unpushed by a previous run are retried in background
+	if ahead, _, err := repo.AheadBehind(context.Background()); err != nil {
+		logrus.Warn(err)
+	} else if ahead > 0 {
+		logrus.Infof("Found %d unpushed commits, scheduling push", ahead)
+		ws.scheduleRetry()
+	}
+
+	go ws.run()
+	if config.SyncInterval > 0 {
+		go ws.syncLoop()
+	}
+	return ws
+}
+
+// syncLoop syncs every SyncInterval until the workspace is closed.
+func (ws *Workspace) syncLoop() {
+	ticker := time.NewTicker(ws.config.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := ws.Sync(context.Background()); err != nil {
+				logrus.Warnf("Background sync failed: %v", err)
+			}
+		case <-ws.closed:
+			return
+		}
+	}
+}
+
+func (ws *Workspace) Path() string {
+	return ws.repo.WorkDir()
+}
+
+func (ws *Workspace) run() {
+	for {
+		select {
+		case j := <-ws.jobs:
+			ws.lock.Lock()
+			err := ws.execute(j)
+			ws.lock.Unlock()
+			j.result <- err
+		case <-ws.closed:
+			return
+		}
+	}
+}
+
+// apply runs fn, a failing job must not take down the writer goroutine.
+func apply(j *job, uow *UnitOfWork) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.Errorf("Job %q panicked: %v", j.message.Subject, r)
+			err = fmt.Errorf("Failed to execute job: %v", r)
+		}
+	}()
+
+	return j.fn(uow)
+}
+
+func (ws *Workspace) writeBehind() bool {
+	return ws.config.WriteBehind > 0
+}
+
+func (ws *Workspace) execute(j *job) error {
+	// The caller does not wait for the result anymore
+	if err := j.ctx.Err(); err != nil {
+		return err
+	}
+
+	if j.task != nil {
+		return j.task(j.ctx)
+	}
+
+	// Local commits waiting for the flush are rebased when flushing
+	if ws.PendingChanges() == 0 {
+		err := ws.fetchAndRebase(j.ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Sync only
+	if j.fn == nil {
+		return nil
+	}
+
+	head, err := ws.repo.Head(j.ctx)
+	if err != nil {
+		return err
+	}
+
+	uow := newUnitOfWork(ws.repo.WorkDir())
+	err = apply(j, uow)
+	if err == nil {
+		message, author := ws.attribute(j.ctx, j.message)
+		err = ws.commit(j.ctx, uow, message, author)
+	}
+	if err != nil {
+		// Roll back even if the caller's context is done already
+		if resetErr := ws.repo.ResetHard(context.Background(), head); resetErr != nil {
+			logrus.Error(resetErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// attribute returns the author of a commit made for the caller stored in
+// ctx, adding a Co-authored-by trailer to message if configured.
+func (ws *Workspace) attribute(ctx context.Context, message *git.Message) (*git.Message, *git.Signature) {
+	author := auth.CallerFrom(ctx)
+	if author != nil && ws.config.Attribution == AttributeTrailer {
+		message.With(git.CoAuthoredBy, author.String())
+		author = nil
+	}
+	return message, author
+}
+
+// commit writes all documents of the unit of work as a single commit.
+// Without write-behind the commit is pushed directly, otherwise a flush
+// is scheduled.
+func (ws *Workspace) commit(ctx context.Context, uow *UnitOfWork, message *git.Message, author *git.Signature) error {
+	err := uow.save()
+	if err != nil {
+		return err
+	}
+
+	changed, err := ws.repo.HasChanges(ctx)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		logrus.Infof("Nothing to commit for %q", message.Subject)
+		return nil
+	}
+
+	err = ws.repo.CommitAll(ctx, message.String(), author)
+	if err != nil {
+		return err
+	}
+
+	if !ws.writeBehind() {
+		ws.push(ctx)
+		return nil
+	}
+
+	ws.pendingMu.Lock()
+	ws.pending = append(ws.pending, change{message, author})
+	ws.pendingMu.Unlock()
+	ws.scheduleFlush()
+	return nil
+}
+
+// scheduleFlush restarts the debounce window of the write-behind buffer.
+func (ws *Workspace) scheduleFlush() {
+	if ws.flushTimer != nil {
+		ws.flushTimer.Stop()
+	}
+	ws.flushTimer = time.AfterFunc(ws.config.WriteBehind, func() {
+		if err := ws.Flush(context.Background()); err != nil {
+			logrus.Error(err)
+		}
+	})
+}
+
+// flush squashes all local commits on top of the upstream branch into a
+// single commit, rebases it and pushes it. If the remote is unreachable,
+// the flush is retried in background.
+func (ws *Workspace) flush(ctx context.Context) error {
+	ws.pendingMu.Lock()
+	pending := append([]change{}, ws.pending...)
+	ws.pendingMu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := ws.repo.Fetch(ctx)
+	if err != nil {
+		ws.syncFailed(err)
+		return err
+	}
+
+	base, err := ws.repo.MergeBase(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = ws.repo.ResetSoft(ctx, base)
+	if err != nil {
+		return err
+	}
+
+	message, author := squash(pending)
+	err = ws.repo.CommitAll(ctx, message.String(), author)
+	if err != nil {
+		return err
+	}
+
+	err = ws.repo.Rebase(ctx)
+	if err != nil {
+		ws.recordError(err)
+		return err
+	}
+
+	err = ws.repo.Push(ctx)
+	if err != nil {
+		ws.syncFailed(err)
+		return err
+	}
+
+	ws.pendingMu.Lock()
+	ws.pending = ws.pending[len(pending):]
+	ws.pendingMu.Unlock()
+	ws.pushed()
+	logrus.Infof("Flushed %d changes", len(pending))
+	return nil
+}
+
+// squash combines the messages of changes, keeping all their trailers.
+// If the changes have different authors, the configured identity is used
+// and all authors are credited with Co-authored-by trailers.
+func squash(changes []change) (*git.Message, *git.Signature) {
+	if len(changes) == 1 {
+		return changes[0].message, changes[0].author
+	}
+
+	author := changes[0].author
+	lines := make([]string, 0, len(changes))
+	for _, c := range changes {
+		if !sameSignature(author, c.author) {
+			author = nil
+		}
+		lines = append(lines, fmt.Sprintf("- %s", c.message.Subject))
+	}
+
+	message := git.NewMessage(fmt.Sprintf("Apply %d changes", len(changes)))
+	message.Body = strings.Join(lines, "\n")
+	// Trailers of every change are kept in order, so actions and tasks
+	// stay paired
+	coAuthors := map[string]bool{}
+	for _, c := range changes {
+		message.Trailers = append(message.Trailers, c.message.Trailers...)
+		if author == nil && c.author != nil && !coAuthors[c.author.String()] {
+			coAuthors[c.author.String()] = true
+			message.With(git.CoAuthoredBy, c.author.String())
+		}
+	}
+	return message, author
+}
+
+func sameSignature(a, b *git.Signature) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (ws *Workspace) submit(j *job) error {
+	select {
+	case ws.jobs <- j:
+	case <-ws.closed:
+		return fmt.Errorf("Workspace %s is closed", ws.repo.WorkDir())
+	}
+	return <-j.result
+}
+
+// Mutate applies fn and commits all documents changed by fn as one commit
+// using message. The caller stored in ctx is recorded as configured by
+// Config.Attribution. Without write-behind, the repository is fetched and
+// rebased before and the commit is pushed directly. If the remote is not
+// reachable, the commit stays local and is pushed in background. If any
+// other step fails, the repository is reset to its previous state. It
+// blocks until the job is done.
+func (ws *Workspace) Mutate(ctx context.Context, message *git.Message, fn Mutation) error {
+	logrus.Tracef("Submitting job: %s", message.Subject)
+	return ws.submit(&job{
+		ctx:     ctx,
+		message: message,
+		fn:      fn,
+		result:  make(chan error, 1),
+	})
+}
+
+// RevertFunc chooses the commits to revert from the synced repository and
+// returns them in the order they are reverted, with the message of the
+// reverting commit.
+type RevertFunc func(ctx context.Context, repo git.Repository) ([]string, *git.Message, error)
+
+// Revert reverts the commits chosen by fn in a single new commit, handled
+// like a mutation by Mutate. If any of them fails, none is reverted.
+func (ws *Workspace) Revert(ctx context.Context, fn RevertFunc) error {
+	j := &job{
+		ctx:     ctx,
+		message: git.NewMessage("Revert"),
+		result:  make(chan error, 1),
+	}
+	j.fn = func(uow *UnitOfWork) error {
+		revs, message, err := fn(ctx, ws.repo)
+		if err != nil {
+			return err
+		}
+		j.message = message
+		for _, rev := range revs {
+			if err := ws.repo.Revert(ctx, rev); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return ws.submit(j)
+}
+
+// Sync fetches and rebases the repository on the writer goroutine. While
+// changes are pending in write-behind mode, the next flush syncs instead.
+// If the remote is not reachable, the local clone is used as is.
+func (ws *Workspace) Sync(ctx context.Context) error {
+	return ws.submit(&job{
+		ctx:     ctx,
+		message: git.NewMessage("Sync"),
+		result:  make(chan error, 1),
+	})
+}
+
+// Flush pushes all changes pending in write-behind mode as one commit.
+func (ws *Workspace) Flush(ctx context.Context) error {
+	return ws.submit(&job{
+		ctx:     ctx,
+		message: git.NewMessage("Flush"),
+		task:    ws.flush,
+		result:  make(chan error, 1),
+	})
+}
+
+// PendingChanges returns the number of changes not pushed yet.
+func (ws *Workspace) PendingChanges() int {
+	ws.pendingMu.Lock()
+	defer ws.pendingMu.Unlock()
+	return len(ws.pending)
+}
+
+// Read runs fn while no mutation is in progress, so fn always sees a
+// consistent state of the working tree. fn must not submit jobs.
+func (ws *Workspace) Read(fn func(path string) error) error {
+	ws.lock.RLock()
+	defer ws.lock.RUnlock()
+	return fn(ws.repo.WorkDir())
+}
+
+// ReadRepository runs fn with the repository while no mutation is in
+// progress, e.g. to read its history. fn must not change the repository.
+func (ws *Workspace) ReadRepository(fn func(repo git.Repository) error) error {
+	ws.lock.RLock()
+	defer ws.lock.RUnlock()
+	return fn(ws.repo)
+}
+
+// ReadAt returns the content of file at rev, or as of the last commit
+// changing file until at if rev is empty, and the revision read. The
+// working tree is not touched.
+func (ws *Workspace) ReadAt(ctx context.Context, file string, rev string, at time.Time) ([]byte, string, error) {
+	var content []byte
+	err := ws.ReadRepository(func(repo git.Repository) error {
+		if rev == "" {
+			commits, err := repo.Log(ctx, file)
+			if err != nil {
+				return err
+			}
+			for _, c := range commits {
+				if !c.Time.After(at) {
+					rev = c.Hash
+					break
+				}
+			}
+			if rev == "" {
+				return fmt.Errorf("No version of %s as of %s: %w", file, at.Format(time.RFC3339), git.ErrNotFound)
+			}
+		}
+
+		var err error
+		content, err = repo.Show(ctx, rev, file)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return content, rev, nil
+}
+
+// Close flushes pending changes and stops the writer goroutine, later
+// submissions fail.
+func (ws *Workspace) Close() {
+	ws.once.Do(func() {
+		if err := ws.Flush(context.Background()); err != nil {
+			logrus.Error(err)
+		}
+		close(ws.closed)
+	})
+}
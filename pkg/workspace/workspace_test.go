@@ -0,0 +1,248 @@
+package workspace
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/martenwallewein/todo-service/pkg/git"
+)
+
+// fileDoc is a document holding the whole content of a file.
+type fileDoc string
+
+func (d fileDoc) WriteToFile(file string) error {
+	return os.WriteFile(file, []byte(d), 0644)
+}
+
+// newRemote returns a remote with a first commit of files.
+func newRemote(t *testing.T, files map[string]string) *git.MemoryRemote {
+	t.Helper()
+	remote := git.NewMemoryRemote()
+	dir := t.TempDir()
+	repo, err := git.NewMemoryRepo(dir, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	if err := repo.CommitAll(ctx, "Initial commit", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Push(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return remote
+}
+
+// newWorkspace clones remote into a workspace, closed when the test ends.
+func newWorkspace(t *testing.T, remote *git.MemoryRemote, config Config) (*Workspace, *git.MemoryRepo) {
+	t.Helper()
+	repo, err := git.NewMemoryRepo(t.TempDir(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := New(repo, config)
+	t.Cleanup(ws.Close)
+	return ws, repo
+}
+
+// remoteLog returns the messages of the commits of remote, newest first.
+func remoteLog(t *testing.T, remote *git.MemoryRemote) []string {
+	t.Helper()
+	repo, err := git.NewMemoryRepo(t.TempDir(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commits, err := repo.Log(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := []string{}
+	for _, c := range commits {
+		messages = append(messages, c.Message)
+	}
+	return messages
+}
+
+func readFile(t *testing.T, ws *Workspace, name string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(ws.Path(), name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestMutateSerializesJobs(t *testing.T) {
+	remote := newRemote(t, map[string]string{"counter.md": ""})
+	ws, _ := newWorkspace(t, remote, Config{})
+
+	const jobs = 10
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := ws.Mutate(context.Background(), git.NewMessage("Count"), func(uow *UnitOfWork) error {
+				content, err := os.ReadFile(filepath.Join(uow.Path(), "counter.md"))
+				if err != nil {
+					return err
+				}
+				uow.Track("counter.md", fileDoc(string(content)+"x\n"))
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := readFile(t, ws, "counter.md"); got != strings.Repeat("x\n", jobs) {
+		t.Errorf("counter.md is %q, want %d lines", got, jobs)
+	}
+	if n := len(remoteLog(t, remote)); n != jobs+1 {
+		t.Errorf("remote has %d commits, want %d", n, jobs+1)
+	}
+}
+
+func TestMutateRollsBackFailedJobs(t *testing.T) {
+	remote := newRemote(t, map[string]string{"todos.md": "before\n"})
+	ws, repo := newWorkspace(t, remote, Config{})
+	head, err := repo.Head(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failure := errors.New("failed")
+	err = ws.Mutate(context.Background(), git.NewMessage("Fail"), func(uow *UnitOfWork) error {
+		if err := os.WriteFile(filepath.Join(uow.Path(), "todos.md"), []byte("half written\n"), 0644); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("Mutate returned %v, want %v", err, failure)
+	}
+
+	if got := readFile(t, ws, "todos.md"); got != "before\n" {
+		t.Errorf("todos.md is %q after rollback", got)
+	}
+	if after, _ := repo.Head(context.Background()); after != head {
+		t.Errorf("head moved from %s to %s", head, after)
+	}
+	if n := len(remoteLog(t, remote)); n != 1 {
+		t.Errorf("remote has %d commits, want 1", n)
+	}
+}
+
+func TestMutateRecoversFromPanics(t *testing.T) {
+	remote := newRemote(t, map[string]string{"todos.md": "before\n"})
+	ws, _ := newWorkspace(t, remote, Config{})
+
+	err := ws.Mutate(context.Background(), git.NewMessage("Panic"), func(uow *UnitOfWork) error {
+		panic("broken")
+	})
+	if err == nil {
+		t.Fatal("Mutate returned no error for a panicking job")
+	}
+	// The writer goroutine is still running
+	err = ws.Mutate(context.Background(), git.NewMessage("Write"), func(uow *UnitOfWork) error {
+		uow.Track("todos.md", fileDoc("after\n"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, ws, "todos.md"); got != "after\n" {
+		t.Errorf("todos.md is %q", got)
+	}
+}
+
+func TestWriteBehindSquashesChanges(t *testing.T) {
+	remote := newRemote(t, map[string]string{"todos.md": ""})
+	ws, _ := newWorkspace(t, remote, Config{WriteBehind: time.Hour})
+
+	for _, task := range []string{"a", "b"} {
+		task := task
+		message := git.NewMessage("Add "+task).With("Todo-Task", task)
+		err := ws.Mutate(context.Background(), message, func(uow *UnitOfWork) error {
+			uow.Track("todos.md", fileDoc(readFile(t, ws, "todos.md")+task+"\n"))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := ws.PendingChanges(); n != 2 {
+		t.Errorf("%d pending changes, want 2", n)
+	}
+	if n := len(remoteLog(t, remote)); n != 1 {
+		t.Errorf("changes pushed before the flush")
+	}
+
+	if err := ws.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := ws.PendingChanges(); n != 0 {
+		t.Errorf("%d pending changes after flush", n)
+	}
+	log := remoteLog(t, remote)
+	if len(log) != 2 {
+		t.Fatalf("remote has %d commits, want 2", len(log))
+	}
+	message := git.ParseMessage(log[0])
+	if message.Subject != "Apply 2 changes" || strings.Join(message.Get("Todo-Task"), ",") != "a,b" {
+		t.Errorf("squashed commit %q", log[0])
+	}
+}
+
+func TestSyncIntervalFetchesInBackground(t *testing.T) {
+	remote := newRemote(t, map[string]string{"todos.md": "before\n"})
+	ws, _ := newWorkspace(t, remote, Config{SyncInterval: 10 * time.Millisecond})
+
+	other, err := git.NewMemoryRepo(t.TempDir(), remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(other.WorkDir(), "todos.md"), []byte("after\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := other.CommitAll(ctx, "Change todos", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Push(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var content []byte
+		err := ws.Read(func(path string) error {
+			var err error
+			content, err = os.ReadFile(filepath.Join(path, "todos.md"))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) == "after\n" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("todos.md is %q, the change of the remote was not synced", content)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}